@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/rhymond/go-money"
+	"github.com/rooprob/chargesim/message"
+)
+
+// Tariff prices a charging session. Rates are in minor units of Currency.
+type Tariff struct {
+	Currency   string
+	PerKWh     int64
+	PerMinute  int64 // while charging
+	SessionFee int64
+	IdleFee    int64 // per minute plugged in once full
+}
+
+func NewTariff(currency string, perKWh int64) *Tariff {
+	return &Tariff{
+		Currency: currency,
+		PerKWh:   perKWh,
+	}
+}
+
+// Cost in minor units of d spent plugged in and taking kWh, or idle when
+// no charge was taken.
+func (t *Tariff) Cost(kWh float64, d time.Duration) float64 {
+	if kWh > 0 {
+		return float64(t.PerKWh)*kWh + float64(t.PerMinute)*d.Minutes()
+	}
+	return float64(t.IdleFee) * d.Minutes()
+}
+
+// Session is a vehicle's visit to a charger, from starting to charge
+// until it leaves. It is priced as it goes, prices may change meanwhile.
+type Session struct {
+	Id         string
+	Charger    *Charger
+	Vehicle    *Vehicle
+	Start, End time.Time
+	KWh        float64
+	Charging   time.Duration
+	Idle       time.Duration // plugged in, taking no charge
+	Exported   float64       // kWh discharged to the grid
+	Currency   string
+	Amount     float64 // minor units
+	Credit     float64 // minor units, paid to the driver for exports
+}
+
+// Billing closes sessions into Transactions, passing each to its
+// listeners and keeping the revenue of every site by day.
+type Billing struct {
+	listeners []func(t *message.Transaction)
+	revenue   map[revenueKey]*Revenue
+	ledger    *Ledger
+}
+
+type revenueKey struct {
+	site, day, currency string
+}
+
+// Revenue of a site over a day, in one currency
+type Revenue struct {
+	Site     string
+	Day      string
+	Sessions int
+	KWh      float64
+	Amount   *money.Money
+	Exported float64      // kWh
+	Payouts  *money.Money // to drivers for exports
+}
+
+func NewBilling(ledger *Ledger) *Billing {
+	return &Billing{
+		revenue: make(map[revenueKey]*Revenue),
+		ledger:  ledger,
+	}
+}
+
+// OnTransaction registers f to receive every completed Transaction
+func (b *Billing) OnTransaction(f func(t *message.Transaction)) {
+	b.listeners = append(b.listeners, f)
+}
+
+// Close bills a finished session, the driver paying the site operator,
+// and the operator paying the driver for any energy exported.
+func (b *Billing) Close(s *Session) *message.Transaction {
+	amount := money.New(int64(math.Round(s.Amount)), s.Currency)
+	err := b.ledger.Post(s.End, DriverAccount(s.Vehicle), OperatorAccount(s.Charger.Site), amount,
+		"charging at "+s.Charger.Name)
+	if err != nil {
+		log.Println(err)
+	}
+	credit := money.New(int64(math.Round(s.Credit)), s.Currency)
+	err = b.ledger.Post(s.End, OperatorAccount(s.Charger.Site), DriverAccount(s.Vehicle), credit,
+		"discharging at "+s.Charger.Name)
+	if err != nil {
+		log.Println(err)
+	}
+
+	t := message.NewTransaction(int(math.Round(s.KWh*1000)), amount)
+	t.Session = s.Id
+	t.Charger = s.Charger.Id
+	t.Site = s.Charger.Site
+	t.Vehicle = s.Vehicle.Id
+	t.Start = s.Start
+	t.End = s.End
+	t.Exported = int(math.Round(s.Exported * 1000))
+	t.Credit = credit
+
+	key := revenueKey{s.Charger.Site, s.End.Format("2006-01-02"), amount.Currency().Code}
+	r, ok := b.revenue[key]
+	if !ok {
+		r = &Revenue{Site: key.site, Day: key.day, Amount: money.New(0, key.currency),
+			Payouts: money.New(0, key.currency)}
+		b.revenue[key] = r
+	}
+	r.Sessions++
+	r.KWh += s.KWh
+	r.Amount, _ = r.Amount.Add(amount)
+	r.Exported += s.Exported
+	r.Payouts, _ = r.Payouts.Add(credit)
+
+	for _, f := range b.listeners {
+		f(t)
+	}
+	return t
+}
+
+// Revenue by site and day, in order
+func (b *Billing) Revenue() []*Revenue {
+	rows := make([]*Revenue, 0, len(b.revenue))
+	for _, r := range b.revenue {
+		rows = append(rows, r)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Site != rows[j].Site {
+			return rows[i].Site < rows[j].Site
+		}
+		if rows[i].Day != rows[j].Day {
+			return rows[i].Day < rows[j].Day
+		}
+		return rows[i].Amount.Currency().Code < rows[j].Amount.Currency().Code
+	})
+	return rows
+}
+
+// Report writes the revenue by site and day as CSV
+func (b *Billing) Report(w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"site", "day", "sessions", "kwh", "revenue", "exported", "payouts", "currency"})
+	for _, r := range b.Revenue() {
+		out.Write([]string{
+			r.Site,
+			r.Day,
+			fmt.Sprintf("%d", r.Sessions),
+			fmt.Sprintf("%.3f", r.KWh),
+			fmt.Sprintf("%d", r.Amount.Amount()),
+			fmt.Sprintf("%.3f", r.Exported),
+			fmt.Sprintf("%d", r.Payouts.Amount()),
+			r.Amount.Currency().Code,
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// VehicleSpec describes a vehicle model's battery and charging limits,
+// and how sensitive it is to the weather.
+type VehicleSpec struct {
+	Model       string
+	CapacityKWh float64
+	MaxACKW     float64 // onboard charger limit
+	MaxDCKW     float64
+	MassKg      float64
+	ColdPenalty float64 // extra consumption per degree below ComfortLow
+	HeatPenalty float64 // extra consumption per degree above ComfortHigh
+	ColdDerate  float64 // lost charge acceptance per degree of battery below ColdCharge
+	V2GKW       float64 // discharge limit to the grid, none if 0
+}
+
+var vehicleCatalog = []*VehicleSpec{
+	{Model: "Model X", CapacityKWh: 100.0, MaxACKW: 16.5, MaxDCKW: 120.0, MassKg: 2450.0,
+		ColdPenalty: 0.010, HeatPenalty: 0.005, ColdDerate: 0.025},
+	{Model: "Model S", CapacityKWh: 85.0, MaxACKW: 16.5, MaxDCKW: 120.0, MassKg: 2100.0,
+		ColdPenalty: 0.010, HeatPenalty: 0.005, ColdDerate: 0.025},
+	// no heat pump or active battery cooling/heating
+	{Model: "Leaf", CapacityKWh: 40.0, MaxACKW: 6.6, MaxDCKW: 50.0, MassKg: 1580.0,
+		ColdPenalty: 0.015, HeatPenalty: 0.006, ColdDerate: 0.035, V2GKW: 6.0},
+	// delivery van, charged overnight at a depot
+	{Model: "E-Transit", CapacityKWh: 68.0, MaxACKW: 11.3, MaxDCKW: 115.0, MassKg: 2700.0,
+		ColdPenalty: 0.012, HeatPenalty: 0.005, ColdDerate: 0.03},
+}
+
+// used for any model missing from the catalog
+var genericVehicle = &VehicleSpec{Model: "generic", CapacityKWh: 60.0, MaxACKW: 7.4, MaxDCKW: 50.0, MassKg: 1800.0,
+	ColdPenalty: 0.012, HeatPenalty: 0.005, ColdDerate: 0.03}
+
+// Temperatures, in Celsius, the weather model is built around
+const (
+	ComfortLow  = 18.0 // cabin heating below
+	ComfortHigh = 25.0 // air conditioning above
+	ColdCharge  = 15.0 // battery limits charge acceptance below
+)
+
+// Climate scales consumption for heating or cooling at ambient celsius
+func (s *VehicleSpec) Climate(celsius float64) float64 {
+	return 1.0 + s.ColdPenalty*math.Max(0, ComfortLow-celsius) + s.HeatPenalty*math.Max(0, celsius-ComfortHigh)
+}
+
+// Acceptance is the share of charging power a battery at celsius takes
+func (s *VehicleSpec) Acceptance(celsius float64) float64 {
+	a := 1.0 - s.ColdDerate*math.Max(0, ColdCharge-celsius)
+	return math.Max(0.1, math.Min(1.0, a))
+}
+
+func LookupVehicle(model string) *VehicleSpec {
+	for _, s := range vehicleCatalog {
+		if s.Model == model {
+			return s
+		}
+	}
+	return genericVehicle
+}
+
+// ChargerSpec describes a charger model's hardware, and how reliable it
+// is: the mean time between failures and to repair once on site.
+type ChargerSpec struct {
+	Model   string
+	AC      bool // destination charger, charging every plugged in vehicle
+	PowerKW float64
+	Stalls  int
+	V2GKW   float64 // bidirectional, discharging vehicles at up to this
+	MTBF    time.Duration
+	MTTR    time.Duration
+}
+
+var chargerCatalog = []*ChargerSpec{
+	{Model: "t1", AC: false, PowerKW: 50.0, Stalls: 1, MTBF: 150 * time.Hour, MTTR: 8 * time.Hour},
+	{Model: "t2", AC: false, PowerKW: 120.0, Stalls: 1, MTBF: 120 * time.Hour, MTTR: 8 * time.Hour},
+	{Model: "ac", AC: true, PowerKW: 7.4, Stalls: 4, MTBF: 400 * time.Hour, MTTR: 12 * time.Hour},
+	{Model: "v2g", AC: true, PowerKW: 7.4, Stalls: 2, V2GKW: 7.4, MTBF: 300 * time.Hour, MTTR: 12 * time.Hour},
+}
+
+var genericCharger = &ChargerSpec{Model: "generic", AC: false, PowerKW: 50.0, Stalls: 1,
+	MTBF: 150 * time.Hour, MTTR: 8 * time.Hour}
+
+func LookupCharger(model string) *ChargerSpec {
+	for _, s := range chargerCatalog {
+		if s.Model == model {
+			return s
+		}
+	}
+	return genericCharger
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rooprob/chargesim/ocpp"
+)
+
+// HeartbeatInterval asked of charge points booting with the central system
+const HeartbeatInterval = 60 * time.Second
+
+var ocppUpgrader = websocket.Upgrader{
+	Subprotocols: []string{ocpp.Subprotocol},
+	CheckOrigin:  func(r *http.Request) bool { return true },
+}
+
+// CentralSystem is a minimal OCPP 1.6J central system. Charge points
+// outside the simulation, other simulators or hardware on a test bench,
+// connect to /ocpp/<identity> and join the track as chargers: their status
+// puts the charger in or out of service, and while they charge their
+// meter values set the power it delivers, the most any connector draws.
+type CentralSystem struct {
+	track        Track
+	world        *World
+	mu           sync.Mutex
+	transactions int
+	connected    map[*Charger]bool // kept by the simulation
+}
+
+func NewCentralSystem(t Track, w *World) *CentralSystem {
+	return &CentralSystem{track: t, world: w, connected: make(map[*Charger]bool)}
+}
+
+// remotePoint is what the central system knows of a connection
+type remotePoint struct {
+	charger      *Charger
+	rated        float64         // kW, when not metering a transaction
+	transactions map[int]int     // connector, by transaction id
+	metered      map[int]float64 // kW drawn, by connector charging
+	lastWh       float64
+	lastAt       time.Time
+}
+
+func (cs *CentralSystem) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	identity := path.Base(r.URL.Path)
+	if identity == "" || identity == "ocpp" || identity == "/" {
+		http.NotFound(w, r)
+		return
+	}
+	socket, err := ocppUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer socket.Close()
+
+	var c *Charger
+	joined := make(chan error)
+	cs.world.Do(func() {
+		var err error
+		c, err = cs.join(identity)
+		joined <- err
+	})
+	if err := <-joined; err != nil {
+		log.Printf("charge point %s refused, %v", identity, err)
+		return
+	}
+	log.Println("charge point connected: ", identity, socket.RemoteAddr())
+	defer cs.world.Do(func() {
+		delete(cs.connected, c)
+		c.Unavailable = true
+		c.Status = "offline"
+	})
+
+	p := &remotePoint{
+		charger:      c,
+		rated:        c.Power,
+		transactions: make(map[int]int),
+		metered:      make(map[int]float64),
+	}
+	for {
+		f, err := readFrame(socket)
+		if err != nil {
+			log.Println("charge point disconnected: ", identity, err)
+			return
+		}
+		if f == nil || f.Type != ocpp.Call {
+			continue
+		}
+		if err := socket.WriteJSON(cs.onCall(p, f)); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+}
+
+// join adds a charger for identity to the track, or finds it again on a
+// reconnect. Refused if a simulated charger has the name, or another
+// connection has the identity.
+func (cs *CentralSystem) join(identity string) (*Charger, error) {
+	c := FindCharger(cs.track, identity)
+	switch {
+	case c != nil && !c.Remote:
+		return nil, errors.New("name taken")
+	case c != nil && cs.connected[c]:
+		return nil, errors.New("already connected")
+	case c == nil:
+		c = NewCharger(identity, "generic", "offline")
+		c.Remote = true
+		c.Unavailable = true
+		cs.track.Add(c)
+	}
+	cs.connected[c] = true
+	return c, nil
+}
+
+// onCall answers a call from the charge point
+func (cs *CentralSystem) onCall(p *remotePoint, f *ocpp.Frame) *ocpp.Frame {
+	var reply interface{}
+	var err error
+	switch f.Action {
+	case ocpp.BootNotification:
+		var req ocpp.BootNotificationReq
+		if err = decodeFrame(f, &req); err == nil {
+			reply = cs.boot(p, &req)
+		}
+	case ocpp.Heartbeat:
+		reply = &ocpp.HeartbeatConf{CurrentTime: time.Now()}
+	case ocpp.Authorize:
+		reply = &ocpp.AuthorizeConf{IdTagInfo: ocpp.IdTagInfo{Status: ocpp.Accepted}}
+	case ocpp.StatusNotification:
+		var req ocpp.StatusNotificationReq
+		if err = decodeFrame(f, &req); err == nil {
+			cs.status(p, &req)
+			reply = &ocpp.StatusNotificationConf{}
+		}
+	case ocpp.StartTransaction:
+		var req ocpp.StartTransactionReq
+		if err = decodeFrame(f, &req); err == nil {
+			cs.mu.Lock()
+			cs.transactions++
+			id := cs.transactions
+			cs.mu.Unlock()
+			p.transactions[id] = req.ConnectorId
+			p.lastWh, p.lastAt = float64(req.MeterStart), req.Timestamp
+			reply = &ocpp.StartTransactionConf{
+				IdTagInfo:     ocpp.IdTagInfo{Status: ocpp.Accepted},
+				TransactionId: id,
+			}
+		}
+	case ocpp.StopTransaction:
+		var req ocpp.StopTransactionReq
+		if err = decodeFrame(f, &req); err == nil {
+			if connector, ok := p.transactions[req.TransactionId]; ok {
+				delete(p.transactions, req.TransactionId)
+				delete(p.metered, connector)
+				cs.power(p)
+			}
+			reply = &ocpp.StopTransactionConf{IdTagInfo: &ocpp.IdTagInfo{Status: ocpp.Accepted}}
+		}
+	case ocpp.MeterValues:
+		var req ocpp.MeterValuesReq
+		if err = decodeFrame(f, &req); err == nil {
+			cs.meter(p, &req)
+			reply = &ocpp.MeterValuesConf{}
+		}
+	default:
+		return ocpp.NewError(f.Id, ocpp.NotImplemented, f.Action)
+	}
+	if err != nil {
+		return ocpp.NewError(f.Id, ocpp.FormationViolation, err.Error())
+	}
+	result, err := ocpp.NewResult(f.Id, reply)
+	if err != nil {
+		return ocpp.NewError(f.Id, ocpp.GenericError, err.Error())
+	}
+	return result
+}
+
+// boot accepts every charge point, taking its power from the catalog if
+// the model is known.
+func (cs *CentralSystem) boot(p *remotePoint, req *ocpp.BootNotificationReq) *ocpp.BootNotificationConf {
+	spec := LookupCharger(req.ChargePointModel)
+	p.rated = spec.PowerKW
+	c := p.charger
+	cs.world.Do(func() {
+		c.Model = req.ChargePointModel
+		c.Power = spec.PowerKW
+		c.Unavailable = false
+		if c.Fault == "" {
+			c.Status = "online"
+		}
+	})
+	return &ocpp.BootNotificationConf{
+		Status:      ocpp.Accepted,
+		CurrentTime: time.Now(),
+		Interval:    int(HeartbeatInterval.Seconds()),
+	}
+}
+
+// status takes the charger in and out of service, any connector standing
+// for the whole charger.
+func (cs *CentralSystem) status(p *remotePoint, req *ocpp.StatusNotificationReq) {
+	c := p.charger
+	cs.world.Do(func() {
+		switch req.Status {
+		case ocpp.Faulted:
+			fault := FaultOutage
+			if req.ErrorCode == ocpp.ReaderFailure {
+				fault = FaultPayment
+			}
+			if c.Fault != fault {
+				c.SetFault(fault)
+			}
+		case ocpp.Unavailable:
+			c.Unavailable = true
+			if c.Fault == "" {
+				c.Status = "unavailable"
+			}
+		default:
+			c.Unavailable = false
+			if c.Fault != "" {
+				c.Repair()
+			}
+			c.Status = "online"
+		}
+	})
+}
+
+// meter records the power a connector draws from the sampled power, or
+// failing that the change in the energy register. Nothing drawn, paused or
+// full, it leaves the others' power be.
+func (cs *CentralSystem) meter(p *remotePoint, req *ocpp.MeterValuesReq) {
+	kW := -1.0
+	for _, mv := range req.MeterValue {
+		for _, sv := range mv.SampledValue {
+			value, err := strconv.ParseFloat(sv.Value, 64)
+			if err != nil {
+				continue
+			}
+			switch sv.Measurand {
+			case ocpp.PowerActiveImport:
+				kW = value / 1000
+				if sv.Unit == "kW" {
+					kW = value
+				}
+			case "", ocpp.EnergyActiveImportRegister:
+				wh := value
+				if sv.Unit == "kWh" {
+					wh = value * 1000
+				}
+				if kW < 0 && !p.lastAt.IsZero() && mv.Timestamp.After(p.lastAt) {
+					kW = (wh - p.lastWh) / mv.Timestamp.Sub(p.lastAt).Hours() / 1000
+				}
+				p.lastWh, p.lastAt = wh, mv.Timestamp
+			}
+		}
+	}
+	if kW <= 0 {
+		return
+	}
+	for _, connector := range p.transactions {
+		if connector == req.ConnectorId {
+			p.metered[connector] = kW
+			cs.power(p)
+			return
+		}
+	}
+}
+
+// power sets the charger to the most any connector draws, or its rated
+// power when none is metered.
+func (cs *CentralSystem) power(p *remotePoint) {
+	kW := p.rated
+	if len(p.metered) > 0 {
+		kW = 0
+		for _, metered := range p.metered {
+			kW = math.Max(kW, metered)
+		}
+	}
+	c := p.charger
+	cs.world.Do(func() {
+		c.Power = kW
+	})
+}
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rooprob/chargesim/ocpp"
+)
+
+// ChargePointRetry is how long a charge point waits to reconnect
+const ChargePointRetry = 5 * time.Second
+
+// ChargePoint emulates an OCPP 1.6J charge point for a Charger, connected
+// to a central system at URL/<charger name>. It reports what the charger
+// does in the simulation, and takes remote commands from the central
+// system.
+type ChargePoint struct {
+	URL           string
+	Vendor        string
+	MeterInterval time.Duration // simulated time between MeterValues
+	charger       *Charger
+	out           chan *ocpp.Frame
+
+	// shared by the simulation and the connection
+	mu           sync.Mutex
+	booted       bool
+	heartbeat    time.Duration
+	pending      map[string]func(f *ocpp.Frame)
+	transactions map[*Vehicle]*pointTransaction
+	unanswered   []*pointTransaction // StartTransaction sent again on each boot
+	connectors   []string            // last status reported, by connector
+	remote       map[*Vehicle]bool
+	register     float64 // kWh delivered, lifetime
+	lastMeter    time.Time
+}
+
+// pointTransaction is a session as the central system knows it
+type pointTransaction struct {
+	id        int // 0 until the central system answers
+	vehicle   *Vehicle
+	connector int
+	start     *ocpp.StartTransactionReq
+	kW        float64
+	stop      *ocpp.StopTransactionReq // stopped before it was answered
+}
+
+func NewChargePoint(c *Charger, url string) *ChargePoint {
+	cp := &ChargePoint{
+		URL:           url,
+		Vendor:        "chargesim",
+		MeterInterval: 5 * time.Minute,
+		charger:       c,
+		out:           make(chan *ocpp.Frame, 256),
+		heartbeat:     5 * time.Minute,
+		pending:       make(map[string]func(f *ocpp.Frame)),
+		transactions:  make(map[*Vehicle]*pointTransaction),
+		connectors:    make([]string, c.Stalls),
+		remote:        make(map[*Vehicle]bool),
+	}
+	c.point = cp
+	return cp
+}
+
+// Run connects to the central system, reconnecting whenever dropped
+func (cp *ChargePoint) Run() {
+	for {
+		if err := cp.connect(); err != nil {
+			log.Printf("charge point %s: %v", cp.charger.Name, err)
+		}
+		time.Sleep(ChargePointRetry)
+	}
+}
+
+// connect boots with the central system, then sends what the simulation
+// queued and handles what comes back until the connection drops.
+func (cp *ChargePoint) connect() error {
+	dialer := websocket.Dialer{Subprotocols: []string{ocpp.Subprotocol}}
+	socket, _, err := dialer.Dial(cp.URL+"/"+url.PathEscape(cp.charger.Name), nil)
+	if err != nil {
+		return err
+	}
+	defer socket.Close()
+	defer cp.disconnected()
+
+	if err := cp.boot(socket); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go cp.write(socket, done)
+
+	for {
+		f, err := readFrame(socket)
+		if err != nil {
+			return err
+		}
+		if f != nil {
+			cp.onFrame(f)
+		}
+	}
+}
+
+// boot sends the BootNotification, waiting on the answer. Nothing else
+// may be sent until it is accepted.
+func (cp *ChargePoint) boot(socket *websocket.Conn) error {
+	call, _ := ocpp.NewCall(ocpp.BootNotification, &ocpp.BootNotificationReq{
+		ChargePointVendor:       cp.Vendor,
+		ChargePointModel:        cp.charger.Model,
+		ChargePointSerialNumber: cp.charger.Name,
+	})
+	if err := socket.WriteJSON(call); err != nil {
+		return err
+	}
+	for {
+		f, err := readFrame(socket)
+		if err != nil {
+			return err
+		}
+		if f == nil || f.Id != call.Id {
+			continue
+		}
+		var conf ocpp.BootNotificationConf
+		if err := decodeFrame(f, &conf); err != nil {
+			return err
+		}
+		if conf.Status != ocpp.Accepted {
+			return fmt.Errorf("boot %s", conf.Status)
+		}
+		cp.mu.Lock()
+		if conf.Interval > 0 {
+			cp.heartbeat = time.Duration(conf.Interval) * time.Second
+		}
+		// report every connector afresh
+		for i := range cp.connectors {
+			cp.connectors[i] = ""
+		}
+		cp.booted = true
+		for _, tx := range cp.unanswered {
+			cp.sendStart(tx)
+		}
+		cp.mu.Unlock()
+		return nil
+	}
+}
+
+// disconnected forgets the calls awaiting an answer, which never will
+// come. Those not yet sent are dropped too, StartTransaction being sent
+// again on the next boot; the rest stay queued.
+func (cp *ChargePoint) disconnected() {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.booted = false
+	var keep []*ocpp.Frame
+	for len(cp.out) > 0 {
+		if f := <-cp.out; cp.pending[f.Id] == nil {
+			keep = append(keep, f)
+		}
+	}
+	for _, f := range keep {
+		cp.enqueue(f)
+	}
+	cp.pending = make(map[string]func(f *ocpp.Frame))
+}
+
+func (cp *ChargePoint) write(socket *websocket.Conn, done chan struct{}) {
+	cp.mu.Lock()
+	heartbeat := time.NewTicker(cp.heartbeat)
+	cp.mu.Unlock()
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-heartbeat.C:
+			cp.call(ocpp.Heartbeat, &ocpp.HeartbeatReq{}, nil)
+		case f := <-cp.out:
+			if err := socket.WriteJSON(f); err != nil {
+				log.Println(err)
+				socket.Close()
+				return
+			}
+		}
+	}
+}
+
+// readFrame reads the next frame, nil if it could not be understood
+func readFrame(socket *websocket.Conn) (*ocpp.Frame, error) {
+	_, data, err := socket.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	var f ocpp.Frame
+	if err := json.Unmarshal(data, &f); err != nil {
+		log.Println(err)
+		return nil, nil
+	}
+	return &f, nil
+}
+
+// decodeFrame decodes the payload of a Call or CallResult into v
+func decodeFrame(f *ocpp.Frame, v interface{}) error {
+	if f.Type == ocpp.CallError {
+		return fmt.Errorf("%s: %s", f.ErrorCode, f.ErrorDescription)
+	}
+	return json.Unmarshal(f.Payload, v)
+}
+
+// enqueue a frame to send, dropping it if the connection is backed up
+func (cp *ChargePoint) enqueue(f *ocpp.Frame) {
+	select {
+	case cp.out <- f:
+	default:
+		log.Printf("charge point %s: dropped %s", cp.charger.Name, f.Action)
+	}
+}
+
+// call the central system, handing the answer to f if given
+func (cp *ChargePoint) call(action string, payload interface{}, f func(reply *ocpp.Frame)) {
+	call, err := ocpp.NewCall(action, payload)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if f != nil {
+		cp.mu.Lock()
+		cp.pending[call.Id] = f
+		cp.mu.Unlock()
+	}
+	cp.enqueue(call)
+}
+
+// reply to a call from the central system
+func (cp *ChargePoint) reply(id string, payload interface{}) {
+	f, err := ocpp.NewResult(id, payload)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	cp.enqueue(f)
+}
+
+func (cp *ChargePoint) onFrame(f *ocpp.Frame) {
+	if f.Type == ocpp.Call {
+		cp.onCall(f)
+		return
+	}
+	cp.mu.Lock()
+	callback, ok := cp.pending[f.Id]
+	delete(cp.pending, f.Id)
+	cp.mu.Unlock()
+	if ok {
+		callback(f)
+	}
+}
+
+// onCall handles the remote commands, in the simulation's own time
+func (cp *ChargePoint) onCall(f *ocpp.Frame) {
+	c := cp.charger
+	switch f.Action {
+	case ocpp.RemoteStartTransaction:
+		var req ocpp.RemoteStartTransactionReq
+		if err := json.Unmarshal(f.Payload, &req); err != nil {
+			cp.enqueue(ocpp.NewError(f.Id, ocpp.FormationViolation, err.Error()))
+			return
+		}
+		c.world.Do(func() {
+			cp.reply(f.Id, &ocpp.StatusConf{Status: cp.remoteStart(req.IdTag)})
+		})
+	case ocpp.RemoteStopTransaction:
+		var req ocpp.RemoteStopTransactionReq
+		if err := json.Unmarshal(f.Payload, &req); err != nil {
+			cp.enqueue(ocpp.NewError(f.Id, ocpp.FormationViolation, err.Error()))
+			return
+		}
+		c.world.Do(func() {
+			cp.reply(f.Id, &ocpp.StatusConf{Status: cp.remoteStop(req.TransactionId)})
+		})
+	case ocpp.ChangeAvailability:
+		var req ocpp.ChangeAvailabilityReq
+		if err := json.Unmarshal(f.Payload, &req); err != nil {
+			cp.enqueue(ocpp.NewError(f.Id, ocpp.FormationViolation, err.Error()))
+			return
+		}
+		c.world.Do(func() {
+			cp.reply(f.Id, &ocpp.StatusConf{Status: cp.changeAvailability(req.Type)})
+		})
+	default:
+		cp.enqueue(ocpp.NewError(f.Id, ocpp.NotImplemented, f.Action))
+	}
+}
+
+// remoteStart lets the vehicle idTag charge: to the front of the queue if
+// already waiting, otherwise holding a stall for it to arrive.
+func (cp *ChargePoint) remoteStart(idTag string) string {
+	c := cp.charger
+	if !c.Online() {
+		return ocpp.Rejected
+	}
+	for _, v := range c.queue {
+		if v.Name != idTag {
+			continue
+		}
+		if !c.AC && c.sessions[v] == nil {
+			c.remove(v)
+			c.reserved[v] = true
+			c.queue = c.insertBooked(v)
+		}
+		return ocpp.Accepted
+	}
+	now := c.world.Clock.Now()
+	if _, err := c.Reserve(idTag, now, now.Add(ReservationGrace)); err != nil {
+		return ocpp.Rejected
+	}
+	return ocpp.Accepted
+}
+
+// remoteStop sends the vehicle on the transaction on its way
+func (cp *ChargePoint) remoteStop(id int) string {
+	cp.mu.Lock()
+	var vehicle *Vehicle
+	for v, tx := range cp.transactions {
+		if tx.id == id {
+			vehicle = v
+			cp.remote[v] = true
+		}
+	}
+	cp.mu.Unlock()
+	if vehicle == nil {
+		return ocpp.Rejected
+	}
+	cp.charger.Eject([]*Vehicle{vehicle})
+	return ocpp.Accepted
+}
+
+// changeAvailability takes the whole charger in or out of service; those
+// charging finish first, those waiting are sent away.
+func (cp *ChargePoint) changeAvailability(kind string) string {
+	c := cp.charger
+	switch kind {
+	case ocpp.Inoperative:
+		c.Unavailable = true
+		if c.Fault == "" {
+			c.Status = "unavailable"
+		}
+		if !c.AC {
+			c.EjectWaiting()
+		}
+		if len(c.sessions) > 0 {
+			return ocpp.Scheduled
+		}
+	case ocpp.Operative:
+		c.Unavailable = false
+		if c.Fault == "" {
+			c.Status = "online"
+		}
+	default:
+		return ocpp.Rejected
+	}
+	return ocpp.Accepted
+}
+
+// Start a transaction for v, on the first free connector
+func (cp *ChargePoint) Start(v *Vehicle) {
+	cp.mu.Lock()
+	used := make(map[int]bool, len(cp.transactions))
+	for _, tx := range cp.transactions {
+		used[tx.connector] = true
+	}
+	tx := &pointTransaction{vehicle: v, connector: 1}
+	for used[tx.connector] {
+		tx.connector++
+	}
+	tx.start = &ocpp.StartTransactionReq{
+		ConnectorId: tx.connector,
+		IdTag:       v.Name,
+		MeterStart:  int(cp.register * 1000),
+		Timestamp:   cp.charger.world.Clock.Now(),
+	}
+	cp.transactions[v] = tx
+	cp.unanswered = append(cp.unanswered, tx)
+	if cp.booted {
+		cp.sendStart(tx)
+	}
+	cp.mu.Unlock()
+}
+
+// sendStart sends tx's StartTransaction, with cp.mu held. Once answered
+// it's stopped, if it already was, or the vehicle sent away if refused.
+func (cp *ChargePoint) sendStart(tx *pointTransaction) {
+	call, err := ocpp.NewCall(ocpp.StartTransaction, tx.start)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	cp.pending[call.Id] = func(reply *ocpp.Frame) {
+		var conf ocpp.StartTransactionConf
+		err := decodeFrame(reply, &conf)
+		if err == nil && conf.IdTagInfo.Status != ocpp.Accepted {
+			err = errors.New(conf.IdTagInfo.Status)
+		}
+		cp.mu.Lock()
+		for i, u := range cp.unanswered {
+			if u == tx {
+				cp.unanswered = append(cp.unanswered[:i], cp.unanswered[i+1:]...)
+				break
+			}
+		}
+		if err == nil {
+			tx.id = conf.TransactionId
+		}
+		stop := tx.stop
+		tx.stop = nil
+		cp.mu.Unlock()
+		if err != nil {
+			// no transaction to stop
+			log.Printf("charge point %s: %s refused, %v", cp.charger.Name, tx.vehicle.Name, err)
+			if stop == nil {
+				cp.charger.world.Do(func() {
+					cp.charger.Eject([]*Vehicle{tx.vehicle})
+				})
+			}
+			return
+		}
+		if stop != nil {
+			stop.TransactionId = tx.id
+			cp.call(ocpp.StopTransaction, stop, nil)
+		}
+	}
+	cp.enqueue(call)
+}
+
+// Stop the transaction for v
+func (cp *ChargePoint) Stop(v *Vehicle) {
+	c := cp.charger
+	reason := ocpp.ReasonLocal
+	if c.AC {
+		reason = ocpp.ReasonEVDisconnected
+	}
+	if c.Fault != "" {
+		reason = ocpp.ReasonOther
+	}
+
+	cp.mu.Lock()
+	tx, ok := cp.transactions[v]
+	if !ok {
+		cp.mu.Unlock()
+		return
+	}
+	delete(cp.transactions, v)
+	if cp.remote[v] {
+		reason = ocpp.ReasonRemote
+		delete(cp.remote, v)
+	}
+	req := &ocpp.StopTransactionReq{
+		IdTag:         v.Name,
+		MeterStop:     int(cp.register * 1000),
+		Timestamp:     c.world.Clock.Now(),
+		TransactionId: tx.id,
+		Reason:        reason,
+	}
+	if tx.id == 0 {
+		// not answered yet, stop once it is. Refused, there's nothing
+		// to stop.
+		for _, u := range cp.unanswered {
+			if u == tx {
+				tx.stop = req
+			}
+		}
+		cp.mu.Unlock()
+		return
+	}
+	cp.mu.Unlock()
+	cp.call(ocpp.StopTransaction, req, nil)
+}
+
+// Meter a tick of v's transaction, kWh delivered
+func (cp *ChargePoint) Meter(v *Vehicle, kWh float64) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.register += kWh
+	if tx, ok := cp.transactions[v]; ok {
+		tx.kW = kWh / cp.charger.world.Clock.Hours()
+	}
+}
+
+// Tick reports connector status changes while connected, and meter
+// values every MeterInterval, queued to send when not.
+func (cp *ChargePoint) Tick() {
+	c := cp.charger
+	now := c.world.Clock.Now()
+
+	cp.mu.Lock()
+	var calls []*ocpp.StatusNotificationReq
+	for i := range cp.connectors {
+		status, code, info := cp.status(i + 1)
+		if !cp.booted || status+code == cp.connectors[i] {
+			continue
+		}
+		cp.connectors[i] = status + code
+		calls = append(calls, &ocpp.StatusNotificationReq{
+			ConnectorId: i + 1,
+			ErrorCode:   code,
+			Status:      status,
+			Info:        info,
+			Timestamp:   &now,
+		})
+	}
+	var meters []*ocpp.MeterValuesReq
+	if now.Sub(cp.lastMeter) >= cp.MeterInterval {
+		cp.lastMeter = now
+		for v, tx := range cp.transactions {
+			req := &ocpp.MeterValuesReq{
+				ConnectorId: tx.connector,
+				MeterValue: []ocpp.MeterValue{{
+					Timestamp: now,
+					SampledValue: []ocpp.SampledValue{
+						{Value: strconv.Itoa(int(cp.register * 1000)), Measurand: ocpp.EnergyActiveImportRegister, Unit: "Wh"},
+						{Value: strconv.Itoa(int(tx.kW * 1000)), Measurand: ocpp.PowerActiveImport, Unit: "W"},
+						{Value: strconv.Itoa(int(v.Charge)), Measurand: ocpp.SoC, Unit: "Percent"},
+					},
+				}},
+			}
+			if tx.id != 0 {
+				id := tx.id
+				req.TransactionId = &id
+			}
+			meters = append(meters, req)
+		}
+	}
+	cp.mu.Unlock()
+
+	for _, req := range calls {
+		cp.call(ocpp.StatusNotification, req, nil)
+	}
+	for _, req := range meters {
+		cp.call(ocpp.MeterValues, req, nil)
+	}
+}
+
+// status of a connector, with the error code and any info
+func (cp *ChargePoint) status(connector int) (string, string, string) {
+	c := cp.charger
+	code, info := ocpp.NoError, ""
+	switch c.Fault {
+	case FaultOutage:
+		code = ocpp.InternalError
+	case FaultPayment:
+		code = ocpp.ReaderFailure
+	case FaultDerated:
+		code, info = ocpp.OtherError, "derated"
+	}
+
+	free := 0 // free connectors before this one
+	for v, tx := range cp.transactions {
+		if tx.connector == connector {
+			if v.Charge >= 100 {
+				return ocpp.SuspendedEV, code, info
+			}
+			return ocpp.Charging, code, info
+		}
+	}
+	for i := 1; i < connector; i++ {
+		free++
+		for _, tx := range cp.transactions {
+			if tx.connector == i {
+				free--
+				break
+			}
+		}
+	}
+	switch {
+	case c.Fault == FaultOutage || c.Fault == FaultPayment:
+		return ocpp.Faulted, code, info
+	case c.Unavailable:
+		return ocpp.Unavailable, code, info
+	case !c.AC && len(c.queue) > 0:
+		return ocpp.Preparing, code, info
+	case free < c.held():
+		return ocpp.Reserved, code, info
+	}
+	return ocpp.Available, code, info
+}
@@ -5,12 +5,16 @@ import (
 	uuid "github.com/satori/go.uuid"
 )
 
+// broadcasts queued for a client before they're dropped
+const outboundBuffer = 256
+
 type Client struct {
 	id       string
 	hub      *Hub
 	color    string
 	socket   *websocket.Conn
 	outbound chan []byte
+	closed   bool // outbound closed, under the hub's lock
 }
 
 func newClient(hub *Hub, socket *websocket.Conn) *Client {
@@ -20,7 +24,7 @@ func newClient(hub *Hub, socket *websocket.Conn) *Client {
 		color:    generateColor(),
 		hub:      hub,
 		socket:   socket,
-		outbound: make(chan []byte),
+		outbound: make(chan []byte, outboundBuffer),
 	}
 }
 
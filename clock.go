@@ -0,0 +1,39 @@
+package main
+
+import (
+	"time"
+)
+
+// Clock maps simulation ticks onto a calendar. Every tick advances the
+// simulated time by Step.
+type Clock struct {
+	Start time.Time
+	Step  time.Duration
+	ticks int
+}
+
+func NewClock(start time.Time, step time.Duration) *Clock {
+	return &Clock{
+		Start: start,
+		Step:  step,
+	}
+}
+
+func (c *Clock) Tick() {
+	c.ticks++
+}
+
+// Ticks elapsed since Start
+func (c *Clock) Ticks() int {
+	return c.ticks
+}
+
+// Now is the simulated time of the current tick
+func (c *Clock) Now() time.Time {
+	return c.Start.Add(time.Duration(c.ticks) * c.Step)
+}
+
+// Hours is the simulated time covered by one tick, for per-hour rates.
+func (c *Clock) Hours() float64 {
+	return c.Step.Hours()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/rooprob/chargesim/message"
+	uuid "github.com/satori/go.uuid"
+)
+
+// A Crew travels the track to repair faulted chargers
+type Crew struct {
+	Id       string
+	Kind     int
+	Color    string
+	Name     string
+	Status   string // idle, travel or repair
+	Velocity float64
+	Speed    float64
+	target   *Charger
+	points   Points
+	world    *World
+}
+
+func NewCrew(name string) *Crew {
+	return &Crew{
+		Id:     uuid.Must(uuid.NewV4()).String(),
+		Kind:   message.KindCrew,
+		Color:  "#ff8800",
+		Name:   name,
+		Status: "idle",
+		Speed:  2.0,
+	}
+}
+
+func (c *Crew) MarshalJSON() ([]byte, error) {
+	target := ""
+	if c.target != nil {
+		target = c.target.Name
+	}
+	return json.Marshal(struct {
+		Id     string `json:"id"`
+		Kind   int    `json:"kind"`
+		Color  string `json:"color"`
+		Points Points `json:"points"`
+		Name   string `json:"name"`
+		Status string `json:"status"`
+		Target string `json:"target"`
+	}{
+		Id:     c.Id,
+		Kind:   c.Kind,
+		Color:  c.Color,
+		Points: c.Points(),
+		Name:   c.Name,
+		Status: c.Status,
+		Target: target,
+	})
+}
+
+func (c *Crew) SetPoints(p Points) {
+	c.points = p
+}
+
+func (c *Crew) Points() Points {
+	return c.points
+}
+
+func (c *Crew) SetWorld(w *World) {
+	c.world = w
+	if w != nil {
+		w.crews = append(w.crews, c)
+	}
+}
+
+// Dispatch the crew to a faulted charger
+func (c *Crew) Dispatch(ch *Charger) {
+	c.world.Event(Event{Kind: DispatchEvent, Crew: c.Name, Charger: ch.Name})
+	c.target = ch
+	c.Status = "travel"
+	ch.crew = c
+}
+
+// Head toward the target along theta radians, the shorter way round,
+// starting the repair on arrival.
+func (c *Crew) Head(dist, theta float64) {
+	if c.Status != "travel" {
+		return
+	}
+	if dist < 1.0 {
+		c.Status = "repair"
+		c.Velocity = 0.0
+		return
+	}
+	c.Velocity = math.Copysign(math.Min(c.Speed, dist), theta)
+}
+
+// Done with the repair, ready for the next
+func (c *Crew) Done() {
+	c.target = nil
+	c.Status = "idle"
+	c.Velocity = 0.0
+}
+
+func (c *Crew) Target() *Charger {
+	return c.target
+}
+
+func (c *Crew) Tick() {
+}
+
+func (c *Crew) Print(prefix string) string {
+	j, err := json.Marshal(c)
+	if err != nil {
+		log.Printf("got error")
+	}
+	return fmt.Sprintf("%s <Crew: %s>\n", prefix, string(j))
+}
+
+func (c *Crew) String() string {
+	return c.Print("/")
+}
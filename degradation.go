@@ -0,0 +1,47 @@
+package main
+
+// Degradation wears batteries down with use, for simulations long enough
+// for it to matter. Capacity fades with every full equivalent cycle, more
+// so when the energy came from a fast charger, and with time spent sitting
+// at a high state of charge.
+type Degradation struct {
+	PerCycle    float64 // capacity lost per full equivalent cycle
+	FastPenalty float64 // multiplies PerCycle for DC charged energy
+	PerHighHour float64 // capacity lost per hour above HighCharge
+	HighCharge  float64
+	Floor       float64 // health never drops below
+}
+
+func NewDegradation() *Degradation {
+	return &Degradation{
+		PerCycle:    0.0002, // ~20% after 1000 cycles
+		FastPenalty: 1.5,
+		PerHighHour: 0.000005, // ~4% after a year kept full
+		HighCharge:  90.0,
+		Floor:       0.5,
+	}
+}
+
+// Cycle wears a battery of health for kWh charged into capacity kWh
+func (d *Degradation) Cycle(health, kWh, capacity float64, fast bool) float64 {
+	fade := d.PerCycle * kWh / capacity
+	if fast {
+		fade = fade * d.FastPenalty
+	}
+	return d.floor(health - fade)
+}
+
+// Age wears a battery of health for hours spent at charge
+func (d *Degradation) Age(health, charge, hours float64) float64 {
+	if charge <= d.HighCharge {
+		return health
+	}
+	return d.floor(health - d.PerHighHour*hours)
+}
+
+func (d *Degradation) floor(health float64) float64 {
+	if health < d.Floor {
+		return d.Floor
+	}
+	return health
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rooprob/chargesim/message"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Demand response event statuses
+const (
+	EventScheduled = "scheduled"
+	EventActive    = "active"
+	EventCompleted = "completed"
+	EventMissed    = "missed" // scheduled for a window already past
+)
+
+// DemandResponse is the grid operator asking sites to cut their draw, in
+// the manner of OpenADR events. Each event holds its sites' grid import to
+// their baseline, their usual draw at those hours, less the reduction
+// asked, through the share of power the sites hand out to their chargers,
+// and reports how well they complied when it ends.
+type DemandResponse struct {
+	events    []*DREvent
+	listeners []func(e *message.DemandResponse)
+}
+
+// DREvent asks a site, or every site when Site is empty, to reduce its
+// grid draw by ReduceKW between From and To.
+type DREvent struct {
+	Id       string
+	Site     string
+	ReduceKW float64
+	From, To time.Time
+	Status   string
+
+	sites []*participant
+}
+
+// participant is a site taking part in an event
+type participant struct {
+	site      *Site
+	baseline  float64 // kW usually drawn over the event's hours
+	unknown   bool    // no history at those hours, so not curtailed
+	target    float64 // kW
+	started   time.Time
+	gridKWh   float64 // site totals when the event began
+	unserved  float64
+	actual    float64 // average kW drawn over the event
+	shortfall float64 // kWh of charging curtailed
+}
+
+func NewDemandResponse() *DemandResponse {
+	return &DemandResponse{}
+}
+
+// Load schedules the events in a CSV of site,from,to,kW rows, an empty
+// site meaning every site. A header row is skipped.
+func (d *DemandResponse) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return err
+	}
+	for i, row := range rows {
+		if len(row) < 4 {
+			return fmt.Errorf("%s:%d: expected site,from,to,kw", path, i+1)
+		}
+		from, ferr := parseTimestamp(row[1])
+		to, terr := parseTimestamp(row[2])
+		kW, kerr := strconv.ParseFloat(row[3], 64)
+		if ferr != nil || terr != nil || kerr != nil {
+			if i == 0 {
+				continue // header
+			}
+			return fmt.Errorf("%s:%d: bad event %q", path, i+1, row)
+		}
+		if _, err := d.Schedule(strings.TrimSpace(row[0]), kW, from, to); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, i+1, err)
+		}
+	}
+	return nil
+}
+
+// OnEvent registers f to hear of every event as it's scheduled, starts
+// and ends.
+func (d *DemandResponse) OnEvent(f func(e *message.DemandResponse)) {
+	d.listeners = append(d.listeners, f)
+}
+
+func (d *DemandResponse) notify(e *DREvent) {
+	m := e.Message()
+	for _, f := range d.listeners {
+		f(m)
+	}
+}
+
+// Schedule an event
+func (d *DemandResponse) Schedule(site string, reduceKW float64, from, to time.Time) (*DREvent, error) {
+	if reduceKW <= 0 {
+		return nil, errors.New("reduction must be positive")
+	}
+	if !to.After(from) {
+		return nil, errors.New("event must end after it starts")
+	}
+	e := &DREvent{
+		Id:       uuid.Must(uuid.NewV4()).String(),
+		Site:     site,
+		ReduceKW: reduceKW,
+		From:     from,
+		To:       to,
+		Status:   EventScheduled,
+	}
+	d.events = append(d.events, e)
+	d.notify(e)
+	return e, nil
+}
+
+func (d *DemandResponse) Events() []*DREvent {
+	return d.events
+}
+
+// Update the events for the tick ending now: ending those due, starting
+// those due, then curtailing each site to the tightest target it's under.
+func (d *DemandResponse) Update(now time.Time, sites []*Site) {
+	for _, e := range d.events {
+		switch {
+		case e.Status == EventActive && !now.Before(e.To):
+			e.end(now)
+			d.notify(e)
+		case e.Status == EventScheduled && !now.Before(e.To):
+			e.Status = EventMissed
+			d.notify(e)
+		case e.Status == EventScheduled && !now.Before(e.From):
+			e.start(now, sites)
+			d.notify(e)
+		}
+	}
+
+	targets := make(map[*Site]float64)
+	for _, e := range d.events {
+		if e.Status != EventActive {
+			continue
+		}
+		for _, p := range e.sites {
+			if p.unknown {
+				continue
+			}
+			if t, ok := targets[p.site]; !ok || p.target < t {
+				targets[p.site] = p.target
+			}
+		}
+	}
+	for _, s := range sites {
+		if t, ok := targets[s]; ok {
+			s.Curtail(t)
+		} else {
+			s.Release()
+		}
+	}
+}
+
+func (e *DREvent) start(now time.Time, sites []*Site) {
+	e.Status = EventActive
+	for _, s := range sites {
+		if e.Site != "" && s.Name != e.Site {
+			continue
+		}
+		baseline, ok := s.Baseline(e.From, e.To)
+		target := math.Max(0, baseline-e.ReduceKW)
+		if !ok {
+			// nothing to cut from, rather than cutting it off
+			target = math.Inf(1)
+		}
+		e.sites = append(e.sites, &participant{
+			site:     s,
+			baseline: baseline,
+			unknown:  !ok,
+			target:   target,
+			started:  now,
+			gridKWh:  s.GridKWh,
+			unserved: s.UnservedKWh,
+		})
+	}
+}
+
+func (e *DREvent) end(now time.Time) {
+	e.Status = EventCompleted
+	for _, p := range e.sites {
+		if hours := now.Sub(p.started).Hours(); hours > 0 {
+			p.actual = (p.site.GridKWh - p.gridKWh) / hours
+		}
+		p.shortfall = p.site.UnservedKWh - p.unserved
+	}
+}
+
+// compliance is the share of the reduction asked that the site made, out
+// of what it could: a site can't cut more than it usually draws.
+func (p *participant) compliance(e *DREvent) float64 {
+	asked := math.Min(e.ReduceKW, p.baseline)
+	if asked <= 0 {
+		return 100
+	}
+	return math.Max(0, math.Min(100, (p.baseline-p.actual)/asked*100))
+}
+
+// Message for the event, with each site's compliance once completed
+func (e *DREvent) Message() *message.DemandResponse {
+	m := &message.DemandResponse{
+		Kind:     message.KindDemandResponse,
+		Id:       e.Id,
+		Site:     e.Site,
+		ReduceKW: e.ReduceKW,
+		From:     e.From,
+		To:       e.To,
+		Status:   e.Status,
+	}
+	if e.Status == EventCompleted {
+		for _, p := range e.sites {
+			c := &message.Compliance{
+				Site:        p.site.Name,
+				BaselineKW:  p.baseline,
+				ActualKW:    p.actual,
+				UnservedKWh: p.shortfall,
+				NoBaseline:  p.unknown,
+			}
+			if !p.unknown {
+				c.TargetKW, c.Compliance = p.target, p.compliance(e)
+			}
+			m.Sites = append(m.Sites, c)
+		}
+	}
+	return m
+}
+
+// Report writes each completed event's compliance by site as CSV
+func (d *DemandResponse) Report(w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"event", "site", "from", "to", "reduce_kw", "baseline_kw", "target_kw", "actual_kw", "compliance", "unserved_kwh"})
+	for _, e := range d.events {
+		if e.Status != EventCompleted {
+			continue
+		}
+		sites := append([]*participant(nil), e.sites...)
+		sort.Slice(sites, func(i, j int) bool {
+			return sites[i].site.Name < sites[j].site.Name
+		})
+		for _, p := range sites {
+			target, compliance := fmt.Sprintf("%.2f", p.target), fmt.Sprintf("%.1f", p.compliance(e))
+			if p.unknown {
+				target, compliance = "", "no baseline"
+			}
+			out.Write([]string{
+				e.Id,
+				p.site.Name,
+				e.From.Format("2006-01-02T15:04"),
+				e.To.Format("2006-01-02T15:04"),
+				fmt.Sprintf("%.2f", e.ReduceKW),
+				fmt.Sprintf("%.2f", p.baseline),
+				target,
+				fmt.Sprintf("%.2f", p.actual),
+				compliance,
+				fmt.Sprintf("%.3f", p.shortfall),
+			})
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// handleDemandResponse lets websocket users schedule events, every user
+// hearing of their progress.
+func handleDemandResponse(hub *Hub, world *World) {
+	world.DemandResponse.OnEvent(func(e *message.DemandResponse) {
+		hub.broadcastAll(e)
+	})
+	hub.handle(message.KindDemandResponse, func(data []byte, client *Client) {
+		var req message.DemandResponse
+		if err := json.Unmarshal(data, &req); err != nil {
+			log.Println(err)
+			return
+		}
+		world.Do(func() {
+			if _, err := world.DemandResponse.Schedule(req.Site, req.ReduceKW, req.From, req.To); err != nil {
+				req.Kind, req.Status, req.Error = message.KindDemandResponse, "refused", err.Error()
+				hub.send(&req, client)
+			}
+		})
+	})
+}
+
+// demandResponseAPI lists events on GET, and schedules one on POST from a
+// JSON body like the websocket message.
+func demandResponseAPI(world *World) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req message.DemandResponse
+		if r.Method == http.MethodPost {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var reply interface{}
+		var err error
+		done := make(chan struct{})
+		world.Do(func() {
+			defer close(done)
+			if r.Method == http.MethodGet {
+				events := []*message.DemandResponse{}
+				for _, e := range world.DemandResponse.Events() {
+					events = append(events, e.Message())
+				}
+				reply = events
+				return
+			}
+			var e *DREvent
+			if e, err = world.DemandResponse.Schedule(req.Site, req.ReduceKW, req.From, req.To); err == nil {
+				reply = e.Message()
+			}
+		})
+		<-done
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+		}
+		if err := json.NewEncoder(w).Encode(reply); err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"time"
+
+	"github.com/rooprob/chargesim/message"
+	uuid "github.com/satori/go.uuid"
+)
+
+// A Depot is where a fleet of vans returns each evening, every van with a
+// stall of its own. Each must leave next morning with a target charge,
+// and the depot's scheduler plans every van's power across the night
+// within what its site can supply, rather than each charging on arrival.
+type Depot struct {
+	Id        string
+	Kind      int
+	Color     string
+	Name      string
+	Site      string
+	Power     float64 // kW per stall
+	Scheduler DepotScheduler
+	Policy    string        // the scheduler's name
+	Slot      time.Duration // planning resolution
+	Fleet     []*FleetVehicle
+
+	// hours of the day vans come back between, and leave between
+	ReturnFrom, ReturnTo float64
+	LeaveFrom, LeaveTo   float64
+	// charge, in percent, vans want to leave with and use on a round
+	TargetMin, TargetMax float64
+	UseMin, UseMax       float64
+
+	Departures []*Departure
+	needs      []*Need
+	planned    time.Time
+	kW         float64 // delivered this tick
+	points     Points
+	world      *World
+}
+
+// FleetVehicle is a van and its timetable. Status is away or plugged.
+type FleetVehicle struct {
+	*Vehicle
+	Arrive  time.Time // next back, while away
+	Depart  time.Time // next leaving, while plugged in
+	Target  float64   // charge wanted by Depart, percent
+	arrived time.Time
+	arrival float64 // charge on arrival
+	kWh     float64 // charged this stay
+}
+
+// Departure records how well a van was charged for its round
+type Departure struct {
+	Vehicle  string
+	Arrived  time.Time
+	Departed time.Time
+	Arrival  float64
+	Target   float64
+	Charge   float64
+	KWh      float64
+}
+
+// Met the target, within rounding
+func (d *Departure) Met() bool {
+	return d.Charge >= d.Target-0.5
+}
+
+func NewDepot(name, model string, size int, policy string) *Depot {
+	d := &Depot{
+		Id:         uuid.Must(uuid.NewV4()).String(),
+		Kind:       message.KindDepot,
+		Color:      "#0088ff",
+		Name:       name,
+		Site:       name,
+		Power:      11.0,
+		Scheduler:  NewDepotScheduler(policy),
+		Policy:     policy,
+		Slot:       15 * time.Minute,
+		ReturnFrom: 17, ReturnTo: 21,
+		LeaveFrom: 5, LeaveTo: 7,
+		TargetMin: 80, TargetMax: 95,
+		UseMin: 30, UseMax: 60,
+	}
+	for i := 0; i < size; i++ {
+		v := NewVehicle(fmt.Sprintf("%s%02d", name, i+1), model, "away", 50.0)
+		d.Fleet = append(d.Fleet, &FleetVehicle{Vehicle: v})
+	}
+	return d
+}
+
+func (d *Depot) MarshalJSON() ([]byte, error) {
+	plugged := 0
+	for _, v := range d.Fleet {
+		if v.Status == "plugged" {
+			plugged++
+		}
+	}
+	return json.Marshal(struct {
+		Id        string  `json:"id"`
+		Kind      int     `json:"kind"`
+		Color     string  `json:"color"`
+		Points    Points  `json:"points"`
+		Name      string  `json:"name"`
+		Site      string  `json:"site"`
+		Scheduler string  `json:"scheduler"`
+		Fleet     int     `json:"fleet"`
+		Plugged   int     `json:"plugged"`
+		KW        float64 `json:"kw"`
+	}{
+		Id:        d.Id,
+		Kind:      d.Kind,
+		Color:     d.Color,
+		Points:    d.Points(),
+		Name:      d.Name,
+		Site:      d.Site,
+		Scheduler: d.Policy,
+		Fleet:     len(d.Fleet),
+		Plugged:   plugged,
+		KW:        d.kW,
+	})
+}
+
+func (d *Depot) SetPoints(p Points) {
+	d.points = p
+}
+
+func (d *Depot) Points() Points {
+	return d.points
+}
+
+// SetWorld opens the depot's site, and sends the fleet out for the day
+func (d *Depot) SetWorld(w *World) {
+	d.world = w
+	if w == nil {
+		return
+	}
+	w.Site(d.Site)
+	now := w.Clock.Now()
+	for _, v := range d.Fleet {
+		v.SetWorld(w)
+		v.Arrive = d.next(now, d.ReturnFrom, d.ReturnTo)
+	}
+}
+
+// next time after t at a random hour of the day between from and to
+func (d *Depot) next(t time.Time, from, to float64) time.Time {
+	hours := d.between(from, to)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	next := day.Add(time.Duration(hours * float64(time.Hour)))
+	if !next.After(t) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func (d *Depot) between(from, to float64) float64 {
+	return from + d.world.Rand.Float64()*(to-from)
+}
+
+// arrive back from a round, plugging in
+func (d *Depot) arrive(v *FleetVehicle, now time.Time) {
+	v.Charge = math.Max(5, v.Charge-d.between(d.UseMin, d.UseMax))
+	v.Status = "plugged"
+	v.Depart = d.next(now, d.LeaveFrom, d.LeaveTo)
+	v.Target = d.between(d.TargetMin, d.TargetMax)
+	v.arrived, v.arrival, v.kWh = now, v.Charge, 0
+}
+
+// depart on a round, however charged
+func (d *Depot) depart(v *FleetVehicle, now time.Time) {
+	dep := &Departure{
+		Vehicle:  v.Name,
+		Arrived:  v.arrived,
+		Departed: now,
+		Arrival:  v.arrival,
+		Target:   v.Target,
+		Charge:   v.Charge,
+		KWh:      v.kWh,
+	}
+	d.Departures = append(d.Departures, dep)
+	if !dep.Met() {
+		d.world.Event(Event{Kind: ShortEvent, Vehicle: v.Name, Site: d.Name, Charge: v.Charge, Target: v.Target})
+	}
+	v.Status = "away"
+	v.Arrive = d.next(now, d.ReturnFrom, d.ReturnTo)
+}
+
+// Plan every plugged in van's power from now until the last leaves
+func (d *Depot) Plan(now time.Time) {
+	d.needs, d.planned = nil, now
+	site := d.world.Site(d.Site)
+	last := now
+	for _, v := range d.Fleet {
+		if v.Status != "plugged" {
+			continue
+		}
+		if v.Depart.After(last) {
+			last = v.Depart
+		}
+		slots := float64(v.Depart.Sub(now)) / float64(d.Slot)
+		d.needs = append(d.needs, &Need{
+			Vehicle:  v,
+			KWh:      math.Max(0, (v.Target-v.Charge)/100*v.Capacity()),
+			MaxKW:    math.Min(d.Power, v.Spec().MaxACKW),
+			Deadline: int(math.Ceil(slots)),
+			Last:     slots - math.Ceil(slots) + 1,
+		})
+	}
+	n := int(math.Ceil(float64(last.Sub(now)) / float64(d.Slot)))
+	slots := &Slots{Length: d.Slot}
+	for i := 0; i < n; i++ {
+		t := now.Add(time.Duration(i) * d.Slot)
+		slots.Start = append(slots.Start, t)
+		slots.Price = append(slots.Price, site.Energy.Price(t))
+		slots.Capacity = append(slots.Capacity, math.Min(site.Limit()+site.solar(t), d.Power*float64(len(d.needs))))
+	}
+	for _, need := range d.needs {
+		need.Profile = make([]float64, n)
+	}
+	d.Scheduler.Plan(d.needs, slots)
+}
+
+// Charge the vans for this tick as planned
+func (d *Depot) Charge(now time.Time) {
+	d.kW = 0
+	slot := int(now.Sub(d.planned) / d.Slot)
+	site := d.world.Site(d.Site)
+	hours := d.world.Clock.Hours()
+	for _, n := range d.needs {
+		v := n.Vehicle
+		if v.Status != "plugged" || n.Rate(slot) <= 0 {
+			continue
+		}
+		kW := math.Min(n.Rate(slot), n.MaxKW*v.Spec().Acceptance(v.BatteryTemp))
+		kW = site.Allocate(kW, now, hours)
+		kWh := v.Energize(kW * hours)
+		v.Wear(kWh, false)
+		site.Draw(kWh)
+		v.kWh += kWh
+		d.kW += kWh / hours
+	}
+}
+
+// Tick brings vans in and sends them out, replanning when they do and at
+// every slot.
+func (d *Depot) Tick() {
+	now := d.world.Clock.Now()
+	replan := false
+	for _, v := range d.Fleet {
+		switch {
+		case v.Status == "away" && !now.Before(v.Arrive):
+			d.arrive(v, now)
+			replan = true
+		case v.Status == "plugged" && !now.Before(v.Depart):
+			d.depart(v, now)
+			replan = true
+		}
+	}
+	if replan || !now.Before(d.planned.Add(d.Slot)) {
+		d.Plan(now)
+	}
+	d.Charge(now)
+}
+
+// Report writes every departure as CSV
+func (d *Depot) Report(w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"vehicle", "arrived", "departed", "arrival_soc", "target_soc", "soc", "kwh", "met"})
+	for _, dep := range d.Departures {
+		out.Write([]string{
+			dep.Vehicle,
+			dep.Arrived.Format("2006-01-02T15:04"),
+			dep.Departed.Format("2006-01-02T15:04"),
+			fmt.Sprintf("%.1f", dep.Arrival),
+			fmt.Sprintf("%.1f", dep.Target),
+			fmt.Sprintf("%.1f", dep.Charge),
+			fmt.Sprintf("%.3f", dep.KWh),
+			fmt.Sprintf("%t", dep.Met()),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func (d *Depot) Print(prefix string) string {
+	j, err := json.Marshal(d)
+	if err != nil {
+		log.Printf("got error")
+	}
+	return fmt.Sprintf("%s <Depot: %s>\n", prefix, string(j))
+}
+
+func (d *Depot) String() string {
+	return d.Print("/")
+}
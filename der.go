@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// Solar is the output of a site's solar array, in kW, at a simulated time
+type Solar interface {
+	Output(t time.Time) float64
+}
+
+// ClearSky is a cloudless day: nothing overnight, rising along a sine from
+// Sunrise to PeakKW at solar noon and back down to Sunset.
+type ClearSky struct {
+	PeakKW          float64
+	Sunrise, Sunset float64 // hours
+}
+
+func NewClearSky(peakKW float64) *ClearSky {
+	return &ClearSky{PeakKW: peakKW, Sunrise: 6, Sunset: 20}
+}
+
+func (s *ClearSky) Output(t time.Time) float64 {
+	hours := float64(t.Hour()) + float64(t.Minute())/60
+	if hours <= s.Sunrise || hours >= s.Sunset {
+		return 0
+	}
+	return s.PeakKW * math.Sin((hours-s.Sunrise)/(s.Sunset-s.Sunrise)*math.Pi)
+}
+
+// SolarSeries interpolates between recorded output, in kW
+type SolarSeries struct {
+	*Series
+}
+
+// LoadSolarSeries reads a CSV of timestamp,kW rows
+func LoadSolarSeries(path string) (*SolarSeries, error) {
+	s, err := LoadSeries(path)
+	if err != nil {
+		return nil, err
+	}
+	return &SolarSeries{s}, nil
+}
+
+func (s *SolarSeries) Output(t time.Time) float64 {
+	return math.Max(0, s.Interpolate(t))
+}
+
+// Battery is a site's stationary storage. Losses are taken charging, so
+// Stored is what can be discharged.
+type Battery struct {
+	CapacityKWh float64
+	PowerKW     float64 // either way
+	Efficiency  float64 // round trip
+	Stored      float64 // kWh
+}
+
+// NewBattery starts out half full
+func NewBattery(kWh, kW float64) *Battery {
+	return &Battery{CapacityKWh: kWh, PowerKW: kW, Efficiency: 0.9, Stored: kWh / 2}
+}
+
+// Available kW to discharge over a tick of hours
+func (b *Battery) Available(hours float64) float64 {
+	return math.Min(b.PowerKW, b.Stored/hours)
+}
+
+// Discharge up to kWh over a tick of hours, returns the kWh supplied
+func (b *Battery) Discharge(kWh, hours float64) float64 {
+	kWh = math.Max(0, math.Min(kWh, b.Available(hours)*hours))
+	b.Stored -= kWh
+	return kWh
+}
+
+// Store up to kWh over a tick of hours, returns the kWh taken in
+func (b *Battery) Store(kWh, hours float64) float64 {
+	room := (b.CapacityKWh - b.Stored) / b.Efficiency
+	kWh = math.Max(0, math.Min(kWh, math.Min(b.PowerKW*hours, room)))
+	b.Stored += kWh * b.Efficiency
+	return kWh
+}
+
+// SoC of the battery, in percent
+func (b *Battery) SoC() float64 {
+	if b.CapacityKWh == 0 {
+		return 0
+	}
+	return b.Stored / b.CapacityKWh * 100
+}
+
+// Flows of energy through a site over a tick, in kWh
+type Flows struct {
+	Load      float64 // drawn by chargers
+	Solar     float64 // solar serving the load
+	Discharge float64 // battery serving the load
+	Stored    float64 // into the battery, from solar or the grid
+	Import    float64 // from the grid
+	Spill     float64 // solar left over, exported
+}
+
+// DispatchPolicy decides how a site meets its load from its solar array,
+// battery and grid connection over a tick.
+type DispatchPolicy interface {
+	Dispatch(s *Site, now time.Time, load, hours float64) Flows
+}
+
+// NewDispatch builds a dispatch policy by name, defaulting to solar
+func NewDispatch(name string) DispatchPolicy {
+	switch name {
+	case "shave":
+		return PeakShave{}
+	}
+	return SolarFirst{}
+}
+
+// SolarFirst serves the load from solar, then the battery, then the grid.
+// Surplus solar charges the battery and the rest is exported.
+type SolarFirst struct{}
+
+func (SolarFirst) Dispatch(s *Site, now time.Time, load, hours float64) Flows {
+	f := Flows{Load: load}
+	sun := s.solar(now) * hours
+	f.Solar = math.Min(sun, load)
+	load -= f.Solar
+	if s.Battery != nil {
+		f.Discharge = s.Battery.Discharge(load, hours)
+		load -= f.Discharge
+		f.Stored = s.Battery.Store(sun-f.Solar, hours)
+	}
+	f.Import = load
+	f.Spill = sun - f.Solar - f.Stored
+	return f
+}
+
+// PeakShave serves the load from solar, then the grid up to the site's
+// limit, saving the battery for load above it. Surplus solar charges the
+// battery, as does the grid while under the billing period's peak so far,
+// and the rest of the solar is exported.
+// Without a limit the battery only soaks up solar.
+type PeakShave struct{}
+
+func (PeakShave) Dispatch(s *Site, now time.Time, load, hours float64) Flows {
+	f := Flows{Load: load}
+	sun := s.solar(now) * hours
+	f.Solar = math.Min(sun, load)
+	load -= f.Solar
+	headroom := s.Limit() * hours
+	f.Import = math.Min(load, headroom)
+	load -= f.Import
+	if s.Battery != nil {
+		f.Discharge = s.Battery.Discharge(load, hours)
+		load -= f.Discharge
+		f.Stored = s.Battery.Store(sun-f.Solar, hours)
+		if !math.IsInf(headroom, 1) {
+			// recharge under the billing period's peak so far, not to set a new one
+			room := math.Min(headroom, s.Peak*hours) - f.Import
+			grid := s.Battery.Store(math.Min(room, s.Battery.PowerKW*hours-f.Stored), hours)
+			f.Stored += grid
+			f.Import += grid
+		}
+	}
+	f.Import += load // over the limit, the sharing should have prevented it
+	f.Spill = sun - f.Solar - f.Stored
+	return f
+}
+
+// DERReport writes each site's energy by source as CSV, with the power it
+// couldn't deliver for want of capacity, and its peak grid draw.
+func DERReport(w io.Writer, sites []*Site) error {
+	sort.Slice(sites, func(i, j int) bool {
+		return sites[i].Name < sites[j].Name
+	})
+	out := csv.NewWriter(w)
+	out.Write([]string{"site", "kwh", "solar", "battery", "grid", "spilled", "unserved", "grid_peak_kw", "limit_kw"})
+	for _, s := range sites {
+		out.Write([]string{
+			s.Name,
+			fmt.Sprintf("%.3f", s.KWh),
+			fmt.Sprintf("%.3f", s.SolarKWh),
+			fmt.Sprintf("%.3f", s.BatteryKWh),
+			fmt.Sprintf("%.3f", s.GridKWh),
+			fmt.Sprintf("%.3f", s.SpilledKWh),
+			fmt.Sprintf("%.3f", s.UnservedKWh),
+			fmt.Sprintf("%.2f", s.GridPeak),
+			fmt.Sprintf("%.2f", s.GridKW),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
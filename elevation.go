@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Elevation is a height profile in metres around a closed track, sampled
+// at evenly spaced points starting from 0 radians and interpolated between.
+type Elevation []float64
+
+// ParseElevation reads a comma separated list of heights
+func ParseElevation(s string) (Elevation, error) {
+	e := Elevation{}
+	for _, f := range strings.Split(s, ",") {
+		h, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil {
+			return nil, err
+		}
+		e = append(e, h)
+	}
+	return e, nil
+}
+
+// Height at theta radians
+func (e Elevation) Height(theta float64) float64 {
+	if len(e) == 0 {
+		return 0.0
+	}
+	pos := math.Mod(theta, 2*math.Pi)
+	if pos < 0 {
+		pos = pos + 2*math.Pi
+	}
+	pos = pos / (2 * math.Pi) * float64(len(e))
+	i := int(pos) % len(e)
+	frac := pos - math.Floor(pos)
+	return e[i]*(1-frac) + e[(i+1)%len(e)]*frac
+}
+
+// Climb totals the metres climbed and descended travelling theta radians
+// from start, negative theta running clockwise.
+func (e Elevation) Climb(start, theta float64) (up, down float64) {
+	if len(e) == 0 || theta == 0 {
+		return 0.0, 0.0
+	}
+	// a few samples per profile point is enough to catch every crest
+	steps := int(math.Ceil(math.Abs(theta)/(2*math.Pi)*float64(len(e))*4)) + 1
+	step := theta / float64(steps)
+	prev := e.Height(start)
+	for i := 1; i <= steps; i++ {
+		h := e.Height(start + step*float64(i))
+		if h > prev {
+			up = up + h - prev
+		} else {
+			down = down + prev - h
+		}
+		prev = h
+	}
+	return up, down
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Environment supplies the ambient temperature, in Celsius, at a simulated
+// time.
+type Environment interface {
+	Temperature(t time.Time) float64
+}
+
+// FixedTemperature is the same all day, every day
+type FixedTemperature float64
+
+func (f FixedTemperature) Temperature(t time.Time) float64 {
+	return float64(f)
+}
+
+// DailyTemperature swings either side of Mean, coldest before dawn and
+// warmest mid afternoon.
+type DailyTemperature struct {
+	Mean, Swing float64
+}
+
+func (d *DailyTemperature) Temperature(t time.Time) float64 {
+	hours := float64(t.Hour()) + float64(t.Minute())/60
+	// peak at 15:00, trough at 03:00
+	return d.Mean + d.Swing*math.Cos((hours-15)/24*2*math.Pi)
+}
+
+// TemperatureSeries interpolates between recorded readings, in Celsius
+type TemperatureSeries struct {
+	*Series
+}
+
+// LoadTemperatureSeries reads a CSV of timestamp,celsius rows
+func LoadTemperatureSeries(path string) (*TemperatureSeries, error) {
+	s, err := LoadSeries(path)
+	if err != nil {
+		return nil, err
+	}
+	return &TemperatureSeries{s}, nil
+}
+
+func (s *TemperatureSeries) Temperature(t time.Time) float64 {
+	return s.Interpolate(t)
+}
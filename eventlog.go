@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Kinds of event written to the log
+const (
+	SpawnEvent       = "spawn"
+	StatusEvent      = "status" // a vehicle's status changed
+	JoinEvent        = "joined" // a fast charger's queue
+	RejectEvent      = "rejected"
+	RefuseEvent      = "refused" // a reservation
+	StartEvent       = "started" // charging
+	StopEvent        = "stopped"
+	FlatEvent        = "flat"
+	TransactionEvent = "transaction"
+	FaultEvent       = "fault"
+	EjectEvent       = "ejected" // from a charger's queue
+	RepairEvent      = "repaired"
+	DispatchEvent    = "dispatched" // a crew to a charger
+	NoShowEvent      = "no_show"    // a reservation
+	ResponseEvent    = "demand_response"
+	ShortEvent       = "left_short" // a depot van, under its target
+)
+
+// Event is a line of the log, stamped with the tick and simulated time it
+// happened. Fields not about the event are left out.
+type Event struct {
+	Tick    int       `json:"tick"`
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"event"`
+	Id      string    `json:"id,omitempty"`
+	Vehicle string    `json:"vehicle,omitempty"`
+	Charger string    `json:"charger,omitempty"`
+	Site    string    `json:"site,omitempty"`
+	Crew    string    `json:"crew,omitempty"`
+	Model   string    `json:"model,omitempty"`
+	From    string    `json:"from,omitempty"`
+	To      string    `json:"to,omitempty"`
+	Charge  float64   `json:"charge,omitempty"` // percent
+	Target  float64   `json:"target,omitempty"` // percent
+	KW      float64   `json:"kw,omitempty"`
+	Booked  bool      `json:"booked,omitempty"`
+	Session string    `json:"session,omitempty"`
+	KWh     float64   `json:"kwh,omitempty"`
+	Amount  int64     `json:"amount,omitempty"` // minor units
+	Credit  int64     `json:"credit,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+}
+
+// EventLog writes events as JSON, one a line, to Path. Once the file
+// reaches MaxBytes it's rotated to Path.1, the older ones moving up to
+// Path.Keep and the oldest dropped. A nil EventLog writes nothing.
+type EventLog struct {
+	Path     string
+	MaxBytes int64 // zero never rotates
+	Keep     int
+
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+	size int64
+}
+
+func NewEventLog(path string, maxBytes int64, keep int) (*EventLog, error) {
+	l := &EventLog{Path: path, MaxBytes: maxBytes, Keep: keep}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open the log for appending, carrying on from any file already there
+func (l *EventLog) open() error {
+	f, err := os.OpenFile(l.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.w, l.size = f, bufio.NewWriter(f), info.Size()
+	return nil
+}
+
+// rotate the full log out of the way and start another
+func (l *EventLog) rotate() error {
+	if err := l.close(); err != nil {
+		return err
+	}
+	if l.Keep > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", l.Path, l.Keep))
+		for i := l.Keep - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", l.Path, i), fmt.Sprintf("%s.%d", l.Path, i+1))
+		}
+		if err := os.Rename(l.Path, l.Path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.Path); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// Write the event
+func (l *EventLog) Write(e *Event) {
+	if l == nil {
+		return
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.w == nil {
+		return
+	}
+	if l.MaxBytes > 0 && l.size > 0 && l.size+int64(len(line)) > l.MaxBytes {
+		if err := l.rotate(); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	n, err := l.w.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (l *EventLog) close() error {
+	if l.w == nil {
+		return nil
+	}
+	err := l.w.Flush()
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	l.file, l.w = nil, nil
+	return err
+}
+
+// Close the log, flushing what's buffered
+func (l *EventLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.close()
+}
+
+// Event stamps e with the tick and simulated time, and writes it to the
+// World's log, if any.
+func (w *World) Event(e Event) {
+	if w == nil || w.Events == nil {
+		return
+	}
+	e.Tick, e.Time = w.Clock.Ticks(), w.Clock.Now()
+	w.Events.Write(&e)
+}
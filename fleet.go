@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Network is what a fleet operator needs of the track: where things are,
+// and how far apart. Positions are opaque to the operator.
+type Network interface {
+	Childs() []Object
+	Position(o Object) float64
+	Distance(from, to float64) float64 // the shorter way, signed by direction
+	Climb(from, to float64) (up, down float64)
+	Somewhere() float64 // a random position
+}
+
+// Ride statuses
+const (
+	RideWaiting   = "waiting"
+	RideAssigned  = "assigned"
+	RideOnboard   = "onboard"
+	RideCompleted = "completed"
+	RideCancelled = "cancelled" // the rider gave up waiting
+	RideStranded  = "stranded"  // the vehicle ran flat
+)
+
+// Ride is a request from a rider to be taken from Pickup to Dropoff
+type Ride struct {
+	Id         string
+	Pickup     float64
+	Dropoff    float64
+	Status     string
+	Requested  time.Time
+	PickedUp   time.Time
+	DroppedOff time.Time
+	Vehicle    *Vehicle
+	Deadhead   float64 // distance driven empty to the pickup
+	Distance   float64 // pickup to dropoff
+}
+
+// FleetOperator runs a ride-hail fleet: riders request rides, a dispatcher
+// assigns them to vehicles, and the operator sends idle vehicles running
+// low to charge, spreading them over the chargers. Fleet vehicles don't
+// follow the drivers' daily routine or choose their own chargers.
+type FleetOperator struct {
+	Name        string
+	Vehicles    []*Vehicle
+	Dispatcher  Dispatcher
+	RequestRate float64       // rides per hour at a demand factor of 1.0
+	MaxWait     time.Duration // riders give up waiting for a pickup after
+	ChargeBelow float64       // idle vehicles sent to charge below, percent
+	Reserve     float64       // charge left at the charger after a ride, percent
+	Speed       float64       // on a ride
+
+	Rides   []*Ride
+	due     float64
+	world   *World
+	charges int
+}
+
+// Dispatcher assigns waiting rides to idle vehicles
+type Dispatcher interface {
+	Assign(f *FleetOperator, net Network, rides []*Ride, idle []*Vehicle)
+}
+
+func NewFleetOperator(name, model string, size int, rate float64) *FleetOperator {
+	f := &FleetOperator{
+		Name:        name,
+		Dispatcher:  NearestFeasible{},
+		RequestRate: rate,
+		MaxWait:     30 * time.Minute,
+		ChargeBelow: 40,
+		Reserve:     10,
+		Speed:       2.0,
+	}
+	for i := 0; i < size; i++ {
+		v := NewVehicle(fmt.Sprintf("%s%02d", name, i+1), model, "parked", 60+float64(i%5)*8)
+		v.Fleet = f
+		f.Vehicles = append(f.Vehicles, v)
+	}
+	return f
+}
+
+// SetWorld makes f the World's fleet operator
+func (f *FleetOperator) SetWorld(w *World) {
+	f.world = w
+	w.Fleet = f
+}
+
+// Cost is the charge, in percent, v uses driving between positions on net,
+// hills and all
+func (v *Vehicle) Cost(net Network, from, to float64) float64 {
+	up, down := net.Climb(from, to)
+	return math.Abs(net.Distance(from, to))*0.1*v.climate()/v.Health + v.TerrainCost(up, down)
+}
+
+// Dispatch runs a tick of the fleet: taking requests, moving rides along,
+// assigning the waiting and sending the idle to charge.
+func (f *FleetOperator) Dispatch(net Network) {
+	now := f.world.Clock.Now()
+	f.request(net, now)
+
+	for _, r := range f.Rides {
+		if r.Status == RideWaiting && now.Sub(r.Requested) >= f.MaxWait {
+			r.Status = RideCancelled
+		}
+	}
+
+	idle := []*Vehicle{}
+	for _, v := range f.Vehicles {
+		switch {
+		case v.ride != nil:
+			f.drive(net, v, now)
+		case v.sent != nil || v.Status == "queued" || v.Status == "charging" || v.Status == "flat":
+			// busy charging, or waiting on a tow
+		default:
+			if v.Status == "drive" {
+				v.Parked() // wait for the next ride where it is
+			}
+			idle = append(idle, v)
+		}
+	}
+
+	waiting := []*Ride{}
+	for _, r := range f.Rides {
+		if r.Status == RideWaiting {
+			waiting = append(waiting, r)
+		}
+	}
+	if len(waiting) > 0 && len(idle) > 0 {
+		f.Dispatcher.Assign(f, net, waiting, idle)
+	}
+	f.sendToCharge(net)
+}
+
+// request any rides due this tick, following the demand profile
+func (f *FleetOperator) request(net Network, now time.Time) {
+	f.due += f.RequestRate * f.world.Demand.Spawn.Factor(now) * f.world.Clock.Hours()
+	for ; f.due >= 1.0; f.due-- {
+		r := &Ride{
+			Id:        uuid.Must(uuid.NewV4()).String(),
+			Pickup:    net.Somewhere(),
+			Dropoff:   net.Somewhere(),
+			Status:    RideWaiting,
+			Requested: now,
+		}
+		r.Distance = math.Abs(net.Distance(r.Pickup, r.Dropoff))
+		f.Rides = append(f.Rides, r)
+	}
+}
+
+// Assign the ride to v, heading for the pickup
+func (f *FleetOperator) Assign(r *Ride, v *Vehicle, net Network) {
+	r.Status = RideAssigned
+	r.Vehicle = v
+	d := net.Distance(net.Position(v), r.Pickup)
+	r.Deadhead = math.Abs(d)
+	v.ride = r
+	// on its way next tick, without driving this one twice
+	v.status("drive")
+	v.Velocity = math.Copysign(math.Min(f.Speed, math.Abs(d)), d)
+}
+
+// drive v along its ride, to the pickup then the dropoff
+func (f *FleetOperator) drive(net Network, v *Vehicle, now time.Time) {
+	r := v.ride
+	if v.Status == "flat" {
+		r.Status = RideStranded
+		v.ride = nil
+		return
+	}
+	target := r.Dropoff
+	if r.Status == RideAssigned {
+		target = r.Pickup
+	}
+	d := net.Distance(net.Position(v), target)
+	if math.Abs(d) < 1.0 {
+		if r.Status == RideAssigned {
+			r.Status, r.PickedUp = RideOnboard, now
+			return
+		}
+		r.Status, r.DroppedOff = RideCompleted, now
+		v.ride = nil
+		v.Parked()
+		return
+	}
+	v.Velocity = math.Copysign(math.Min(f.Speed, math.Abs(d)), d)
+}
+
+// sendToCharge sends idle vehicles running low to the charger that will
+// see them soonest: distance, plus the vehicles already there or on the
+// way to it.
+func (f *FleetOperator) sendToCharge(net Network) {
+	chargers := []*Charger{}
+	for _, o := range net.Childs() {
+		if c, ok := o.(*Charger); ok && !c.AC && c.Online() {
+			chargers = append(chargers, c)
+		}
+	}
+	if len(chargers) == 0 {
+		return
+	}
+	load := make(map[*Charger]int)
+	for _, c := range chargers {
+		load[c] = len(c.queue)
+	}
+	for _, v := range f.Vehicles {
+		if v.sent != nil {
+			load[v.sent]++
+		}
+	}
+	for _, v := range f.Vehicles {
+		if v.ride != nil || v.sent != nil || v.Status != "parked" || v.Charge >= f.ChargeBelow {
+			continue
+		}
+		at := net.Position(v)
+		var best *Charger
+		score := math.Inf(1)
+		for _, c := range chargers {
+			if load[c]+c.held() >= c.QueueCapacity {
+				continue // wait for room rather than circle
+			}
+			if v.Cost(net, at, net.Position(c)) > v.Charge {
+				continue
+			}
+			dist := math.Abs(net.Distance(at, net.Position(c)))
+			if s := dist + float64(load[c])*QueuePenalty; s < score {
+				best, score = c, s
+			}
+		}
+		if best == nil {
+			continue
+		}
+		load[best]++
+		f.charges++
+		v.sent = best
+		v.status("drive")
+		v.Velocity = f.Speed
+	}
+}
+
+// QueuePenalty is how much further a driver would go to skip each vehicle
+// ahead at a charger
+const QueuePenalty = 100.0
+
+// NearestFeasible gives each ride, oldest first, to the nearest idle
+// vehicle with the charge to reach the pickup, take the rider and still
+// make a charger after.
+type NearestFeasible struct{}
+
+func (NearestFeasible) Assign(f *FleetOperator, net Network, rides []*Ride, idle []*Vehicle) {
+	var chargers []float64
+	for _, o := range net.Childs() {
+		if c, ok := o.(*Charger); ok && !c.AC && c.Online() {
+			chargers = append(chargers, net.Position(c))
+		}
+	}
+	// to reach the nearest charger after a dropoff
+	reserve := func(v *Vehicle, at float64) float64 {
+		nearest := 0.0
+		for i, c := range chargers {
+			if cost := v.Cost(net, at, c); i == 0 || cost < nearest {
+				nearest = cost
+			}
+		}
+		return nearest
+	}
+
+	sort.SliceStable(rides, func(i, j int) bool {
+		return rides[i].Requested.Before(rides[j].Requested)
+	})
+	taken := make(map[*Vehicle]bool)
+	for _, r := range rides {
+		var best *Vehicle
+		nearest := math.Inf(1)
+		for _, v := range idle {
+			if taken[v] {
+				continue
+			}
+			at := net.Position(v)
+			cost := v.Cost(net, at, r.Pickup) + v.Cost(net, r.Pickup, r.Dropoff) + reserve(v, r.Dropoff)
+			if cost > v.Charge-f.Reserve {
+				continue
+			}
+			pickup := math.Abs(net.Distance(at, r.Pickup))
+			if pickup < nearest {
+				best, nearest = v, pickup
+			}
+		}
+		if best == nil {
+			continue
+		}
+		taken[best] = true
+		f.Assign(r, best, net)
+	}
+}
+
+// Report writes every ride as CSV, times in minutes
+func (f *FleetOperator) Report(w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"ride", "status", "requested", "vehicle", "wait", "trip", "deadhead", "distance"})
+	for _, r := range f.Rides {
+		vehicle, wait, trip := "", "", ""
+		if r.Vehicle != nil {
+			vehicle = r.Vehicle.Name
+		}
+		if !r.PickedUp.IsZero() {
+			wait = fmt.Sprintf("%.1f", r.PickedUp.Sub(r.Requested).Minutes())
+		}
+		if !r.DroppedOff.IsZero() {
+			trip = fmt.Sprintf("%.1f", r.DroppedOff.Sub(r.PickedUp).Minutes())
+		}
+		out.Write([]string{
+			r.Id,
+			r.Status,
+			r.Requested.Format("2006-01-02T15:04"),
+			vehicle,
+			wait,
+			trip,
+			fmt.Sprintf("%.1f", r.Deadhead),
+			fmt.Sprintf("%.1f", r.Distance),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// Summary of the fleet's service: rides requested, completed and lost,
+// the average wait for a pickup, and trips sent to charge.
+func (f *FleetOperator) Summary() string {
+	var completed, lost int
+	var wait time.Duration
+	for _, r := range f.Rides {
+		switch r.Status {
+		case RideCompleted:
+			completed++
+			wait += r.PickedUp.Sub(r.Requested)
+		case RideCancelled, RideStranded:
+			lost++
+		}
+	}
+	avg := 0.0
+	if completed > 0 {
+		avg = wait.Minutes() / float64(completed)
+	}
+	return fmt.Sprintf("fleet %s: %d vehicles, %d rides requested, %d completed, %d lost, %.1f min average wait, %d sent to charge",
+		f.Name, len(f.Vehicles), len(f.Rides), completed, lost, avg, f.charges)
+}
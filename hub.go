@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"sync"
+	"sync/atomic"
 
 	"github.com/gorilla/websocket"
 	"github.com/rooprob/chargesim/message"
@@ -11,9 +13,13 @@ import (
 )
 
 type Hub struct {
+	mu         sync.RWMutex // clients, and closing their outbound channels
 	clients    []*Client
 	register   chan *Client
 	unregister chan *Client
+	handlers   map[int]func(data []byte, client *Client)
+	connected  int64 // clients, atomically
+	dropped    int64 // broadcasts a slow client had no room for, atomically
 }
 
 func newHub() *Hub {
@@ -21,9 +27,15 @@ func newHub() *Hub {
 		clients:    make([]*Client, 0),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		handlers:   make(map[int]func(data []byte, client *Client)),
 	}
 }
 
+// handle messages of kind from clients with f
+func (hub *Hub) handle(kind int, f func(data []byte, client *Client)) {
+	hub.handlers[kind] = f
+}
+
 func (hub *Hub) run() {
 	for {
 		select {
@@ -48,39 +60,79 @@ func (hub *Hub) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	client := newClient(hub, socket)
+	hub.mu.Lock()
 	hub.clients = append(hub.clients, client)
+	hub.mu.Unlock()
 	hub.register <- client
 	client.run()
 }
 
+// send to a client, unless it's gone
 func (hub *Hub) send(message interface{}, client *Client) {
 	data, _ := json.Marshal(message)
-	client.outbound <- data
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	if !client.closed {
+		hub.offer(data, client)
+	}
 }
 
 func (hub *Hub) broadcast(message interface{}, ignore *Client) {
 	data, _ := json.Marshal(message)
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
 	for _, c := range hub.clients {
 		if c != ignore {
-			c.outbound <- data
+			hub.offer(data, c)
 		}
 	}
 }
 
 func (hub *Hub) broadcastAll(message interface{}) {
 	data, _ := json.Marshal(message)
+	hub.broadcastData(data)
+}
+
+// broadcastData sends a message already marshalled to every client
+func (hub *Hub) broadcastData(data []byte) {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
 	for _, c := range hub.clients {
-		c.outbound <- data
+		hub.offer(data, c)
 	}
 }
 
+// offer data to client, dropping it rather than holding up the
+// simulation for a client that's fallen behind. Under hub.mu, so the
+// channel can't close.
+func (hub *Hub) offer(data []byte, client *Client) {
+	select {
+	case client.outbound <- data:
+	default:
+		atomic.AddInt64(&hub.dropped, 1)
+	}
+}
+
+// Connected clients
+func (hub *Hub) Connected() int64 {
+	return atomic.LoadInt64(&hub.connected)
+}
+
+// Dropped broadcasts, since the start
+func (hub *Hub) Dropped() int64 {
+	return atomic.LoadInt64(&hub.dropped)
+}
+
 func (hub *Hub) onConnect(client *Client) {
 	log.Println("client connected: ", client.socket.RemoteAddr())
+	atomic.AddInt64(&hub.connected, 1)
 	// Make list of all users
 	users := []message.User{}
+	hub.mu.RLock()
 	for _, c := range hub.clients {
 		users = append(users, message.User{ID: c.id, Color: c.color})
 	}
+	hub.mu.RUnlock()
 	// Notify user joined
 	hub.send(message.NewConnected(client.color, users), client)
 	hub.broadcast(message.NewUserJoined(client.id, client.color), client)
@@ -88,7 +140,8 @@ func (hub *Hub) onConnect(client *Client) {
 
 func (hub *Hub) onDisconnect(client *Client) {
 	log.Println("client disconnected: ", client.socket.RemoteAddr())
-	client.close()
+	atomic.AddInt64(&hub.connected, -1)
+	hub.mu.Lock()
 	// Find index of client
 	i := -1
 	for j, c := range hub.clients {
@@ -97,14 +150,28 @@ func (hub *Hub) onDisconnect(client *Client) {
 			break
 		}
 	}
-	// Delete client from list
-	copy(hub.clients[i:], hub.clients[i+1:])
-	hub.clients[len(hub.clients)-1] = nil
-	hub.clients = hub.clients[:len(hub.clients)-1]
+	// Delete client from list, then close it with nothing sending
+	if i >= 0 {
+		copy(hub.clients[i:], hub.clients[i+1:])
+		hub.clients[len(hub.clients)-1] = nil
+		hub.clients = hub.clients[:len(hub.clients)-1]
+	}
+	client.closed = true
+	client.close()
+	hub.mu.Unlock()
 	// Notify user left
 	hub.broadcast(message.NewUserLeft(client.id), nil)
 }
 
 func (hub *Hub) onMessage(data []byte, client *Client) {
-	// pass
+	var m struct {
+		Kind int `json:"kind"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		log.Println(err)
+		return
+	}
+	if f, ok := hub.handlers[m.Kind]; ok {
+		f(data, client)
+	}
 }
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/rhymond/go-money"
+)
+
+// Accounts money moves between
+const UtilityAccount = "utility"
+
+func DriverAccount(v *Vehicle) string {
+	return "driver:" + v.Name
+}
+
+func OperatorAccount(site string) string {
+	return "operator:" + site
+}
+
+// Entry is a single double-entry posting, moving Amount from the Credit
+// account to the Debit account.
+type Entry struct {
+	Seq    int
+	Time   time.Time
+	Debit  string
+	Credit string
+	Amount *money.Money
+	Memo   string
+}
+
+// Ledger is an append-only record of every monetary movement. Balances are
+// debits less credits, kept per currency, so across all accounts they
+// always sum to zero.
+type Ledger struct {
+	entries  []*Entry
+	balances map[string]map[string]*money.Money // account, currency
+}
+
+func NewLedger() *Ledger {
+	return &Ledger{
+		balances: make(map[string]map[string]*money.Money),
+	}
+}
+
+// Post moves amount from one account to another
+func (l *Ledger) Post(t time.Time, from, to string, amount *money.Money, memo string) error {
+	if amount == nil || amount.IsNegative() {
+		return errors.New("ledger: amount must not be negative")
+	}
+	if from == to {
+		return errors.New("ledger: cannot post to the same account")
+	}
+	if amount.IsZero() {
+		return nil
+	}
+	debit, err := l.Balance(to, amount.Currency().Code).Add(amount)
+	if err != nil {
+		return err
+	}
+	credit, err := l.Balance(from, amount.Currency().Code).Subtract(amount)
+	if err != nil {
+		return err
+	}
+	l.set(to, debit)
+	l.set(from, credit)
+	l.entries = append(l.entries, &Entry{
+		Seq:    len(l.entries) + 1,
+		Time:   t,
+		Debit:  to,
+		Credit: from,
+		Amount: amount,
+		Memo:   memo,
+	})
+	return nil
+}
+
+func (l *Ledger) set(account string, balance *money.Money) {
+	if l.balances[account] == nil {
+		l.balances[account] = make(map[string]*money.Money)
+	}
+	l.balances[account][balance.Currency().Code] = balance
+}
+
+// Balance of an account in currency
+func (l *Ledger) Balance(account, currency string) *money.Money {
+	if b, ok := l.balances[account][currency]; ok {
+		return b
+	}
+	return money.New(0, currency)
+}
+
+// Accounts with any postings, in order
+func (l *Ledger) Accounts() []string {
+	accounts := make([]string, 0, len(l.balances))
+	for a := range l.balances {
+		accounts = append(accounts, a)
+	}
+	sort.Strings(accounts)
+	return accounts
+}
+
+// Entries so far, oldest first
+func (l *Ledger) Entries() []*Entry {
+	entries := make([]*Entry, len(l.entries))
+	copy(entries, l.entries)
+	return entries
+}
+
+// Check replays every entry and confirms the balances match and each
+// currency nets to zero across all accounts.
+func (l *Ledger) Check() error {
+	replay := NewLedger()
+	for _, e := range l.entries {
+		if err := replay.Post(e.Time, e.Credit, e.Debit, e.Amount, e.Memo); err != nil {
+			return err
+		}
+	}
+	totals := make(map[string]int64)
+	for account, balances := range l.balances {
+		for currency, b := range balances {
+			if ok, _ := replay.Balance(account, currency).Equals(b); !ok {
+				return fmt.Errorf("ledger: %s %s balance does not match its entries", account, currency)
+			}
+			totals[currency] += b.Amount()
+		}
+	}
+	for currency, total := range totals {
+		if total != 0 {
+			return fmt.Errorf("ledger: %s is out of balance by %d", currency, total)
+		}
+	}
+	return nil
+}
+
+// WriteCSV exports every entry
+func (l *Ledger) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"seq", "time", "debit", "credit", "amount", "currency", "memo"})
+	for _, e := range l.entries {
+		out.Write([]string{
+			fmt.Sprintf("%d", e.Seq),
+			e.Time.Format(time.RFC3339),
+			e.Debit,
+			e.Credit,
+			fmt.Sprintf("%d", e.Amount.Amount()),
+			e.Amount.Currency().Code,
+			e.Memo,
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rhymond/go-money"
+)
+
+var ledgerStart = time.Date(2018, 6, 4, 6, 0, 0, 0, time.UTC)
+
+func balance(l *Ledger, account string) int64 {
+	return l.Balance(account, "USD").Amount()
+}
+
+func TestLedgerPost(t *testing.T) {
+	l := NewLedger()
+	if err := l.Post(ledgerStart, "a", "b", money.New(100, "USD"), "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Post(ledgerStart, "a", "b", money.New(0, "USD"), "nothing"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Post(ledgerStart, "a", "b", money.New(-1, "USD"), "negative"); err == nil {
+		t.Error("posted a negative amount")
+	}
+	if err := l.Post(ledgerStart, "a", "a", money.New(1, "USD"), "same"); err == nil {
+		t.Error("posted to the same account")
+	}
+	if got := len(l.Entries()); got != 1 {
+		t.Errorf("%d entries, want 1", got)
+	}
+	if a, b := balance(l, "a"), balance(l, "b"); a != -100 || b != 100 {
+		t.Errorf("balances a %d b %d, want -100 and 100", a, b)
+	}
+	if err := l.Check(); err != nil {
+		t.Error(err)
+	}
+
+	// a balance its entries don't account for
+	l.set("b", money.New(101, "USD"))
+	if err := l.Check(); err == nil {
+		t.Error("checked a balance that doesn't match its entries")
+	}
+}
+
+func TestLedgerBilledSession(t *testing.T) {
+	l := NewLedger()
+	b := NewBilling(l)
+	c := NewCharger("A", "t1", "online")
+	v := NewVehicle("V", "Leaf", "drive", 50)
+	b.Close(&Session{
+		Charger:  c,
+		Vehicle:  v,
+		Start:    ledgerStart,
+		End:      ledgerStart.Add(time.Hour),
+		KWh:      10,
+		Currency: "USD",
+		Amount:   450.4,
+		Credit:   25,
+	})
+
+	// the driver pays for charging, and is paid for exporting
+	if got := balance(l, DriverAccount(v)); got != -425 {
+		t.Errorf("driver balance %d, want -425", got)
+	}
+	if got := balance(l, OperatorAccount("A")); got != 425 {
+		t.Errorf("operator balance %d, want 425", got)
+	}
+	if err := l.Check(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLedgerSiteEnergy(t *testing.T) {
+	l := NewLedger()
+	s := NewSite("A", "USD", FlatEnergy(15), 0)
+	clock := NewClock(ledgerStart, time.Hour)
+	clock.Tick()
+	s.Draw(10)
+	s.Settle(clock, l)
+
+	if got := balance(l, OperatorAccount("A")); got != -150 {
+		t.Errorf("operator balance %d, want -150", got)
+	}
+	if got := balance(l, UtilityAccount); got != 150 {
+		t.Errorf("utility balance %d, want 150", got)
+	}
+	if got := s.EnergyCost.Amount(); got != 150 {
+		t.Errorf("energy cost %d, want 150", got)
+	}
+	if err := l.Check(); err != nil {
+		t.Error(err)
+	}
+}
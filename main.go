@@ -2,11 +2,19 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"runtime"
+	"strings"
 	"time"
+
+	"github.com/rooprob/chargesim/message"
 )
 
 var Interval time.Duration
@@ -63,31 +71,93 @@ func handleInput(done chan int) {
 	}
 }
 
-func handleRuntime(t1 Track, tick chan int, render chan Object) {
+// handleRuntime ticks the track until stop closes, then closes render
+// so nothing touches the simulation after.
+func handleRuntime(t1 Track, tick chan int, render chan Object, stop chan struct{}) {
+	defer close(render)
 	for {
 		fmt.Println("runtime...")
 		t1.Tick()
 
 		t1.Render(render)
-		<-tick
+		select {
+		case <-tick:
+		case <-stop:
+			return
+		}
 	}
 }
 
-func handleRender(hub *Hub, tick chan int, render chan Object) {
+// handleRender broadcasts each object rendered, recording every tick's
+// if rec isn't nil, until render closes, then closes rendered
+func handleRender(hub *Hub, tick chan int, render chan Object, rec *Recorder, rendered chan struct{}) {
 	// previous := time.Now()
-	var v Object
-	for {
-		v = <-render
-
-		hub.broadcastAll(v)
+	defer close(rendered)
+	for v := range render {
+		data, err := json.Marshal(v)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if _, ok := v.(Track); ok {
+			// a new tick, the track rendered first
+			rec.Frame()
+		}
+		rec.Add(data)
+		hub.broadcastData(data)
 		fmt.Println(v)
 	}
 }
 
-func handleServer(hub *Hub) {
+// handleReservations books stalls for websocket users, replying with the
+// reservation or why it was refused.
+func handleReservations(hub *Hub, t Track, world *World) {
+	hub.handle(message.KindReserve, func(data []byte, client *Client) {
+		var req message.Reserve
+		if err := json.Unmarshal(data, &req); err != nil {
+			log.Println(err)
+			return
+		}
+		world.Do(func() {
+			reply := message.NewReservation("", req.Charger, req.Vehicle, req.From, req.To, "refused")
+			c := FindCharger(t, req.Charger)
+			if c == nil {
+				reply.Error = "no such charger"
+			} else if r, err := c.Reserve(req.Vehicle, req.From, req.To); err != nil {
+				reply.Error = err.Error()
+			} else {
+				reply.Id, reply.Status = r.Id, r.Status
+			}
+			hub.send(reply, client)
+		})
+	})
+}
+
+// handleGridSignal lets websocket users signal vehicles to discharge
+func handleGridSignal(hub *Hub, world *World) {
+	hub.handle(message.KindGridSignal, func(data []byte, client *Client) {
+		var req message.GridSignal
+		if err := json.Unmarshal(data, &req); err != nil {
+			log.Println(err)
+			return
+		}
+		world.Do(func() {
+			if world.V2G != nil {
+				world.V2G.Signal = req.Discharge
+			}
+		})
+	})
+}
+
+func handleServer(hub *Hub, world *World, cs *CentralSystem, api *OCPI) {
 	assets := http.StripPrefix("/", http.FileServer(http.Dir("client/")))
 	http.Handle("/", assets)
 	http.HandleFunc("/ws", hub.handleWebSocket)
+	http.HandleFunc("/ocpp/", cs.handleWebSocket)
+	http.HandleFunc("/dr/events", demandResponseAPI(world))
+	http.HandleFunc("/stats", statsAPI(world))
+	http.HandleFunc("/metrics", prometheusAPI(world, hub))
+	api.Handle(http.DefaultServeMux)
 	err := http.ListenAndServe(":3000", nil)
 	if err != nil {
 		log.Fatal(err)
@@ -95,21 +165,240 @@ func handleServer(hub *Hub) {
 }
 
 func main() {
+	start := flag.String("start", "2018-06-04T06:00", "simulated start date and time")
+	step := flag.Duration("step", time.Minute, "simulated time per tick")
+	spawnRate := flag.Float64("spawn", 4.0, "vehicles joining per hour at average demand")
+	spawnMax := flag.Int("max", 8, "maximum vehicles on the track")
+	temp := flag.Float64("temp", 20.0, "ambient temperature, celsius")
+	swing := flag.Float64("swing", 0.0, "daily temperature swing either side of -temp, celsius")
+	weather := flag.String("weather", "", "CSV of timestamp,celsius readings, overrides -temp")
+	degrade := flag.Bool("degrade", false, "wear batteries down with use")
+	elevation := flag.String("elevation", "", "comma separated heights in metres, evenly spaced around the track")
+	regen := flag.Float64("regen", 0.6, "share of energy recovered descending")
+	faults := flag.Bool("faults", false, "chargers fail at random and need repair")
+	crews := flag.Int("crews", 0, "maintenance crews repairing faulted chargers, none repairs in place")
+	csms := flag.String("ocpp", "", "central system URL each charger connects to as an OCPP 1.6J charge point")
+	chargers := flag.Int("chargers", 0, "extra fast chargers on the track, for load")
+	v2g := flag.Bool("v2g", false, "vehicles discharge to the grid over the evening peak, adds a bidirectional charger")
+	v2gPrice := flag.Int64("v2g-price", 25, "paid to drivers per kWh discharged, minor units")
+	v2gReport := flag.String("v2g-report", "", "write dispatchable capacity by hour as CSV to this file on quit")
+	pricing := flag.String("pricing", "flat", "pricing policy for fast chargers: flat, tou or surge")
+	energy := flag.String("energy", "flat", "site energy price: flat, tou or a CSV of timestamp,price per kWh")
+	energyPrice := flag.Float64("energy-price", 15.0, "base site energy price per kWh, minor units")
+	demandCharge := flag.Int64("demand-charge", 0, "site demand charge per peak kW per month, minor units")
+	gridLimit := flag.Float64("grid-limit", 0, "site grid connection limit, kW, zero for none")
+	solar := flag.Float64("solar", 0, "peak kW of a clear sky solar array at each site")
+	solarCSV := flag.String("solar-csv", "", "CSV of timestamp,kW solar output at each site, overrides -solar")
+	battery := flag.Float64("battery", 0, "kWh of stationary battery at each site")
+	batteryKW := flag.Float64("battery-kw", 50, "stationary battery power limit, kW")
+	dispatch := flag.String("dispatch", "solar", "site dispatch: solar (solar, battery, then grid) or shave (battery above the grid limit)")
+	derReport := flag.String("der-report", "", "write site energy by source as CSV to this file on quit")
+	dr := flag.String("dr", "", "CSV of site,from,to,kW demand response events, an empty site for every site")
+	drReport := flag.String("dr-report", "", "write demand response compliance by event and site as CSV to this file on quit")
+	depotSize := flag.Int("depot", 0, "vans in a fleet depot, charged overnight for their morning departures")
+	depotScheduler := flag.String("depot-scheduler", "edf", "depot smart charging: edf (earliest deadline first), price or peak")
+	depotLimit := flag.Float64("depot-limit", 0, "depot site grid limit, kW, overrides -grid-limit")
+	depotReport := flag.String("depot-report", "", "write every depot departure as CSV to this file on quit")
+	fleetSize := flag.Int("fleet", 0, "ride-hail vehicles dispatched by a fleet operator")
+	fleetRate := flag.Float64("fleet-rate", 4, "ride requests per hour at average demand")
+	fleetModel := flag.String("fleet-model", "Model S", "ride-hail vehicle model")
+	fleetReport := flag.String("fleet-report", "", "write every ride request as CSV to this file on quit")
+	seed := flag.Int64("seed", 0, "random seed, zero for the time")
+	optimize := flag.String("optimize", "", "search headless for where to build the -budget chargers: greedy, anneal or genetic")
+	budget := flag.String("budget", "t1,t1,ac", "comma separated models of the chargers to place with -optimize")
+	optimizeFor := flag.Duration("optimize-for", 24*time.Hour, "simulated time each -optimize run")
+	optimizeSeeds := flag.Int("optimize-seeds", 3, "seeds each -optimize placement is run over, from -seed")
+	sweep := flag.Bool("sweep", false, "run every combination of the -sweep ranges headless, over every -sweep-seeds")
+	sweepVehicles := flag.String("sweep-vehicles", "", "most vehicles on the track: a list like 4,8 or range like 4:16:4, default -max")
+	sweepChargers := flag.String("sweep-chargers", "", "chargers, models cycling through -budget, default as many as -budget")
+	sweepQueues := flag.String("sweep-queue", "3", "fast charger queue capacities")
+	sweepSeeds := flag.String("sweep-seeds", "1:5", "seeds to run each combination over")
+	sweepFor := flag.Duration("sweep-for", 24*time.Hour, "simulated time each -sweep run")
+	sweepOut := flag.String("sweep-out", "", "write the sweep's table to this file, JSON if .json, default CSV on stdout")
+	workers := flag.Int("workers", runtime.NumCPU(), "runs at once for -sweep")
+	vehicleStats := flag.String("vehicle-stats", "", "write every vehicle's time driving, waiting and charging as CSV to this file on quit")
+	chargerStats := flag.String("charger-stats", "", "write every charger's occupancy, energy and queues as CSV to this file on quit")
+	record := flag.String("record", "", "record every tick as broadcast to this file, gzipped, for -replay")
+	replayFile := flag.String("replay", "", "serve a -record file at /ws, with play, pause, seek and speed controls, instead of simulating")
+	events := flag.String("events", "", "write every simulation event as a line of JSON to this file")
+	eventsMax := flag.Int64("events-max", 64, "rotate the -events file once it reaches this many MB, zero never")
+	eventsKeep := flag.Int("events-keep", 5, "rotated -events files kept, as file.1 the newest to file.N")
+	pnl := flag.String("pnl", "", "write profit and loss by site as CSV to this file on quit, default stdout")
+	ledger := flag.String("ledger", "", "write every ledger entry as CSV to this file on quit")
+	revenue := flag.String("revenue", "", "write revenue by site and day as CSV to this file on quit, default stdout")
+	flag.Parse()
 
 	Interval, _ = time.ParseDuration("199ms")
 
+	if *replayFile != "" {
+		replay(*replayFile)
+		return
+	}
+
+	startTime, err := time.ParseInLocation("2006-01-02T15:04", *start, time.Local)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	var elev Elevation
+	if *elevation != "" {
+		elev, err = ParseElevation(*elevation)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	scenario := Scenario{
+		Start:     startTime,
+		Step:      *step,
+		Length:    *optimizeFor,
+		Seed:      *seed,
+		Radius:    120.0,
+		Elevation: elev,
+		Temp:      *temp,
+		SpawnRate: *spawnRate,
+		Vehicles:  *spawnMax,
+		Chargers:  strings.Split(*budget, ","),
+	}
+	if *optimize != "" {
+		optimizePlacement(&scenario, *optimize, *optimizeSeeds)
+		return
+	}
+	if *sweep {
+		if *workers < 1 {
+			log.Fatalf("-workers must be at least 1, not %d", *workers)
+		}
+		scenario.Length = *sweepFor
+		s := &Sweep{Base: scenario, Workers: *workers}
+		ranges := []struct {
+			flag   string
+			values *[]int
+			base   int
+		}{
+			{*sweepVehicles, &s.Vehicles, *spawnMax},
+			{*sweepChargers, &s.Chargers, len(scenario.Chargers)},
+			{*sweepQueues, &s.Queues, 0},
+		}
+		for _, r := range ranges {
+			*r.values = []int{r.base}
+			if r.flag != "" {
+				if *r.values, err = ParseRange(r.flag, 0); err != nil {
+					log.Fatal(err)
+				}
+			}
+		}
+		// any seed will do
+		seeds, err := ParseRange(*sweepSeeds, math.MinInt32)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, seed := range seeds {
+			s.Seeds = append(s.Seeds, int64(seed))
+		}
+		runSweep(s, *sweepOut)
+		return
+	}
+
 	v1 := NewVehicle("AAA", "Model X", "drive", 99.0)
 	/*v2 := NewVehicle("BBB", "Model X", "drive", 35.0)
 	v3 := NewVehicle("CCC", "Model S", "drive", 25.0)
 	v4 := NewVehicle("ZZZ", "Leaf", "drive", 70.0) */
 
 	c1 := NewCharger("A", "t1", "online")
+	c1.Pricing = NewPricing(*pricing)
+	d1 := NewCharger("D", "ac", "online")
 	/*c2 := NewCharger("B", "t1", "online")
 	c3 := NewCharger("C", "t2", "online") */
 
 	t1 := NewCircularTrack("T", Points{180.0, 135.0}, 120.0)
+	world := NewWorld(NewClock(startTime, *step), *seed)
+	switch {
+	case *weather != "":
+		series, err := LoadTemperatureSeries(*weather)
+		if err != nil {
+			log.Fatal(err)
+		}
+		world.Environment = series
+	case *swing != 0.0:
+		world.Environment = &DailyTemperature{Mean: *temp, Swing: *swing}
+	default:
+		world.Environment = FixedTemperature(*temp)
+	}
+
+	if *degrade {
+		world.Degradation = NewDegradation()
+	}
+	if *events != "" {
+		world.Events, err = NewEventLog(*events, *eventsMax<<20, *eventsKeep)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	world.Regen = *regen
+	world.Faults = *faults
+	if *v2g {
+		world.V2G = NewV2G(*v2gPrice)
+	}
+	world.Energy, err = NewEnergyPrice(*energy, *energyPrice)
+	if err != nil {
+		log.Fatal(err)
+	}
+	world.DemandCharge = *demandCharge
+	world.GridKW = *gridLimit
+	switch {
+	case *solarCSV != "":
+		series, err := LoadSolarSeries(*solarCSV)
+		if err != nil {
+			log.Fatal(err)
+		}
+		world.Solar = series
+	case *solar > 0:
+		world.Solar = NewClearSky(*solar)
+	}
+	world.BatteryKWh, world.BatteryKW = *battery, *batteryKW
+	world.Dispatch = NewDispatch(*dispatch)
+	if *dr != "" {
+		if err := world.DemandResponse.Load(*dr); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	t1.SetWorld(world)
+	t1.SetSpawner(NewSpawner(*spawnRate, *spawnMax))
+	t1.SetElevation(elev)
 	t1.Add(v1)
 	t1.Add(c1)
+	t1.Add(d1)
+	if *v2g {
+		t1.Add(NewCharger("V", "v2g", "online"))
+	}
+	for i := 0; i < *chargers; i++ {
+		c := NewCharger(fmt.Sprintf("L%03d", i+1), "t1", "online")
+		c.Pricing = NewPricing(*pricing)
+		t1.Add(c)
+	}
+	var depot *Depot
+	if *depotSize > 0 {
+		depot = NewDepot("F", "E-Transit", *depotSize, *depotScheduler)
+		t1.Add(depot)
+		if *depotLimit > 0 {
+			world.Site(depot.Site).GridKW = *depotLimit
+		}
+	}
+	var fleet *FleetOperator
+	if *fleetSize > 0 {
+		fleet = NewFleetOperator("R", *fleetModel, *fleetSize, *fleetRate)
+		fleet.SetWorld(world)
+		for _, v := range fleet.Vehicles {
+			t1.Add(v)
+		}
+	}
+	for i := 0; i < *crews; i++ {
+		t1.Add(NewCrew(fmt.Sprintf("M%d", i+1)))
+	}
 	/*t1.Add(v2)
 	t1.Add(v3)
 	t1.Add(v4)
@@ -117,21 +406,112 @@ func main() {
 	t1.Add(c3) */
 
 	t1.RandomizeObjects()
+	if *csms != "" {
+		for _, c := range t1.Childs() {
+			if c, ok := c.(*Charger); ok {
+				go NewChargePoint(c, *csms).Run()
+			}
+		}
+	}
 
 	tick := make(chan int)
 	done := make(chan int)
 
 	render := make(chan Object)
 
+	hub := newHub()
+	world.Billing.OnTransaction(func(t *message.Transaction) {
+		hub.broadcastAll(t)
+	})
+	handleReservations(hub, t1, world)
+	handleGridSignal(hub, world)
+	handleDemandResponse(hub, world)
+
 	go ticker(tick)
 	// go limited(done, tick)
 	go handleInput(done)
-	go handleRuntime(t1, tick, render)
+	stop := make(chan struct{})
+	rendered := make(chan struct{})
+	go handleRuntime(t1, tick, render, stop)
 
-	hub := newHub()
 	go hub.run()
-	go handleRender(hub, tick, render)
-	go handleServer(hub)
+	var rec *Recorder
+	if *record != "" {
+		rec, err = NewRecorder(*record)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	go handleRender(hub, tick, render, rec, rendered)
+	go handleServer(hub, world, NewCentralSystem(t1, world), NewOCPI(t1, world))
 
 	<-done
+	// stop ticking, and rendering the last tick, before closing the books
+	close(stop)
+	<-rendered
+
+	world.Close()
+	if err := world.Events.Close(); err != nil {
+		log.Println(err)
+	}
+	if rec != nil {
+		if err := rec.Close(); err != nil {
+			log.Println(err)
+		}
+		fmt.Printf("recorded %d ticks to %s\n", rec.Frames, *record)
+	}
+	fmt.Println(world.Metrics.Summary())
+	if *vehicleStats != "" {
+		writeReport(*vehicleStats, world.Metrics.VehicleReport)
+	}
+	if *chargerStats != "" {
+		writeReport(*chargerStats, world.Metrics.ChargerReport)
+	}
+	if err := world.Ledger.Check(); err != nil {
+		log.Println(err)
+	}
+	if *ledger != "" {
+		writeReport(*ledger, world.Ledger.WriteCSV)
+	}
+	writeReport(*revenue, world.Billing.Report)
+	if world.V2G != nil && *v2gReport != "" {
+		writeReport(*v2gReport, world.V2G.Report)
+	}
+	if depot != nil && *depotReport != "" {
+		writeReport(*depotReport, depot.Report)
+	}
+	if fleet != nil {
+		fmt.Println(fleet.Summary())
+		if *fleetReport != "" {
+			writeReport(*fleetReport, fleet.Report)
+		}
+	}
+	if *drReport != "" {
+		writeReport(*drReport, world.DemandResponse.Report)
+	}
+	if *derReport != "" {
+		writeReport(*derReport, func(w io.Writer) error {
+			return DERReport(w, world.Sites())
+		})
+	}
+	writeReport(*pnl, func(w io.Writer) error {
+		return ProfitReport(w, world.Sites(), world.Billing)
+	})
+}
+
+// writeReport writes a report to path, or stdout
+func writeReport(path string, report func(w io.Writer) error) {
+	out := os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := report(out); err != nil {
+		log.Println(err)
+	}
 }
@@ -1,6 +1,9 @@
 package message
 
 import (
+	"encoding/json"
+	"time"
+
 	"github.com/rhymond/go-money"
 	uuid "github.com/satori/go.uuid"
 )
@@ -22,6 +25,22 @@ const (
 	KindClear
 	// KindTransaction
 	KindTransaction
+	// KindReserve is sent by a user booking a stall
+	KindReserve
+	// KindReservation confirms or refuses a booking
+	KindReservation
+	// KindCrew is a maintenance crew
+	KindCrew
+	// KindGridSignal asks vehicles to discharge to the grid, or stop
+	KindGridSignal
+	// KindDemandResponse schedules a demand response event, and reports
+	// its progress and each site's compliance
+	KindDemandResponse
+	// KindDepot is a fleet depot
+	KindDepot
+	// KindReplay plays, pauses, seeks or sets the speed of a recording
+	// being replayed, and reports where it's up to
+	KindReplay
 )
 
 type User struct {
@@ -72,17 +91,141 @@ type Clear struct {
 	UserID string `json:"userId"`
 }
 
+// Transaction is a completed, billed charging session. Units, and any
+// Exported back to the grid for a Credit, are Wh.
 type Transaction struct {
-	Kind   int          `json:"kind"`
-	Id     string       `json:"id"`
-	Units  int          `json:"units"`
-	Amount money.Amount `json:"amount"`
+	Kind     int          `json:"kind"`
+	Id       string       `json:"id"`
+	Units    int          `json:"units"`
+	Amount   *money.Money `json:"-"`
+	Exported int          `json:"exported"`
+	Credit   *money.Money `json:"-"`
+	Session  string       `json:"session"`
+	Charger  string       `json:"charger"`
+	Site     string       `json:"site"`
+	Vehicle  string       `json:"vehicle"`
+	Start    time.Time    `json:"start"`
+	End      time.Time    `json:"end"`
 }
 
-func NewTransaction(units int, amount money.Amount) *Transaction {
+func NewTransaction(units int, amount *money.Money) *Transaction {
 	return &Transaction{
 		Kind:   KindTransaction,
 		Id:     uuid.Must(uuid.NewV4()).String(),
+		Units:  units,
 		Amount: amount,
 	}
 }
+
+// MarshalJSON writes Amount as minor units with its currency code
+func (t *Transaction) MarshalJSON() ([]byte, error) {
+	type transaction Transaction
+	credit := int64(0)
+	if t.Credit != nil {
+		credit = t.Credit.Amount()
+	}
+	return json.Marshal(struct {
+		*transaction
+		Amount   int64  `json:"amount"`
+		Credit   int64  `json:"credit"`
+		Currency string `json:"currency"`
+		Display  string `json:"display"`
+	}{
+		transaction: (*transaction)(t),
+		Amount:      t.Amount.Amount(),
+		Credit:      credit,
+		Currency:    t.Amount.Currency().Code,
+		Display:     t.Amount.Display(),
+	})
+}
+
+// Reserve asks for a stall at a charger, by id or name, for a vehicle
+// arriving between From and To.
+type Reserve struct {
+	Kind    int       `json:"kind"`
+	Charger string    `json:"charger"`
+	Vehicle string    `json:"vehicle"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+}
+
+type Reservation struct {
+	Kind    int       `json:"kind"`
+	Id      string    `json:"id"`
+	Charger string    `json:"charger"`
+	Vehicle string    `json:"vehicle"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+}
+
+func NewReservation(id, charger, vehicle string, from, to time.Time, status string) *Reservation {
+	return &Reservation{
+		Kind:    KindReservation,
+		Id:      id,
+		Charger: charger,
+		Vehicle: vehicle,
+		From:    from,
+		To:      to,
+		Status:  status,
+	}
+}
+
+// GridSignal starts or stops vehicles discharging to the grid outside the
+// usual windows.
+type GridSignal struct {
+	Kind      int  `json:"kind"`
+	Discharge bool `json:"discharge"`
+}
+
+// DemandResponse asks sites, or every site when Site is empty, to reduce
+// their grid draw by ReduceKW between From and To.
+type DemandResponse struct {
+	Kind     int           `json:"kind"`
+	Id       string        `json:"id"`
+	Site     string        `json:"site,omitempty"`
+	ReduceKW float64       `json:"reduceKw"`
+	From     time.Time     `json:"from"`
+	To       time.Time     `json:"to"`
+	Status   string        `json:"status,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Sites    []*Compliance `json:"sites,omitempty"`
+}
+
+// Compliance of a site with a demand response event, against its usual
+// draw over the event's hours.
+type Compliance struct {
+	Site        string  `json:"site"`
+	BaselineKW  float64 `json:"baselineKw"`
+	TargetKW    float64 `json:"targetKw"`
+	ActualKW    float64 `json:"actualKw"`
+	Compliance  float64 `json:"compliance"` // percent of the reduction asked
+	UnservedKWh float64 `json:"unservedKwh"`
+	NoBaseline  bool    `json:"noBaseline,omitempty"` // not curtailed, no draw at those hours yet
+}
+
+// Replay controls a recording being replayed: Action is play, pause, seek
+// to Frame, or speed to play at Speed times the recorded pace. The replay
+// answers every control with its state, Action empty.
+type Replay struct {
+	Kind    int     `json:"kind"`
+	Action  string  `json:"action,omitempty"`
+	Frame   int     `json:"frame"`
+	Speed   float64 `json:"speed,omitempty"`
+	Playing bool    `json:"playing"`
+	Frames  int     `json:"frames"`
+	Time    string  `json:"time"` // simulated, of Frame
+	Error   string  `json:"error,omitempty"`
+}
+
+func NewReplay(frame, frames int, speed float64, playing bool, t string) *Replay {
+	return &Replay{
+		Kind:    KindReplay,
+		Frame:   frame,
+		Frames:  frames,
+		Speed:   speed,
+		Playing: playing,
+		Time:    t,
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"time"
+)
+
+// Metrics records how every vehicle and charger spends the run, observed
+// at the end of each tick. It can be read between ticks for the run so
+// far, and summarized at the end.
+type Metrics struct {
+	Ticks    int
+	Hours    float64       // simulated
+	TickTime time.Duration // running the last tick
+	Running  time.Duration // running every tick
+	Vehicles []*VehicleStats
+	Chargers []*ChargerStats
+
+	vehicles map[*Vehicle]*VehicleStats
+	chargers map[*Charger]*ChargerStats
+}
+
+// VehicleStats is a vehicle's time by status, in hours, and the distance
+// it's driven
+type VehicleStats struct {
+	Name     string  `json:"name"`
+	Driving  float64 `json:"driving"`
+	Parked   float64 `json:"parked"`
+	Waiting  float64 `json:"waiting"` // queued at a charger
+	Charging float64 `json:"charging"`
+	Distance float64 `json:"distance"`
+	Visits   int     `json:"visits"` // to fast chargers
+	Flats    int     `json:"flats"`
+
+	status   string
+	position float64
+}
+
+// ChargerStats is a charger's use over the run. Occupancy is the share of
+// stall time plugged in, in percent.
+type ChargerStats struct {
+	Name      string        `json:"name"`
+	Stalls    int           `json:"stalls"`
+	Hours     float64       `json:"hours"`
+	Occupied  float64       `json:"occupied"` // stall hours
+	Occupancy float64       `json:"occupancy"`
+	KWh       float64       `json:"kwh"`
+	Rejected  int           `json:"rejected"`
+	Queue     int           `json:"queue"` // length now
+	MaxQueue  int           `json:"maxQueue"`
+	MeanQueue float64       `json:"meanQueue"`
+	Queues    []QueueSample `json:"queues"` // each change in length
+}
+
+// QueueSample is a queue's length from Time until the next sample
+type QueueSample struct {
+	Time   time.Time `json:"time"`
+	Length int       `json:"length"`
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		vehicles: make(map[*Vehicle]*VehicleStats),
+		chargers: make(map[*Charger]*ChargerStats),
+	}
+}
+
+// Observe the tick just run on net, taking took
+func (m *Metrics) Observe(net Network, clock *Clock, took time.Duration) {
+	m.Ticks++
+	m.TickTime = took
+	m.Running += took
+	hours := clock.Hours()
+	m.Hours += hours
+	for _, child := range net.Childs() {
+		switch o := child.(type) {
+		case *Vehicle:
+			m.vehicle(o, net, hours)
+		case *Charger:
+			m.charger(o, clock.Now(), hours)
+		}
+	}
+}
+
+func (m *Metrics) vehicle(v *Vehicle, net Network, hours float64) {
+	position := net.Position(v)
+	s, ok := m.vehicles[v]
+	if !ok {
+		s = &VehicleStats{Name: v.Name, position: position}
+		m.vehicles[v] = s
+		m.Vehicles = append(m.Vehicles, s)
+	}
+	switch v.Status {
+	case "drive":
+		s.Driving += hours
+	case "parked":
+		s.Parked += hours
+	case "queued":
+		s.Waiting += hours
+	case "charging":
+		s.Charging += hours
+	}
+	at := v.Status == "queued" || v.Status == "charging"
+	if at && s.status != "queued" && s.status != "charging" {
+		s.Visits++
+	}
+	if v.Status == "flat" && s.status != "flat" {
+		s.Flats++
+	}
+	s.Distance += math.Abs(net.Distance(s.position, position))
+	s.status, s.position = v.Status, position
+}
+
+func (m *Metrics) charger(c *Charger, now time.Time, hours float64) {
+	s, ok := m.chargers[c]
+	if !ok {
+		s = &ChargerStats{Name: c.Name, Stalls: c.Stalls}
+		m.chargers[c] = s
+		m.Chargers = append(m.Chargers, s)
+	}
+	s.Hours += hours
+	s.Occupied += float64(len(c.sessions)) * hours
+	if s.Hours > 0 && s.Stalls > 0 {
+		s.Occupancy = s.Occupied / (s.Hours * float64(s.Stalls)) * 100
+	}
+	s.KWh, s.Rejected = c.Delivered, c.Rejected
+
+	// waiting, not those plugged in
+	queue := len(c.queue) - len(c.sessions)
+	if queue < 0 {
+		queue = 0
+	}
+	if len(s.Queues) == 0 || queue != s.Queue {
+		s.Queues = append(s.Queues, QueueSample{now, queue})
+	}
+	s.MeanQueue += (float64(queue) - s.MeanQueue) * hours / s.Hours
+	s.Queue = queue
+	if queue > s.MaxQueue {
+		s.MaxQueue = queue
+	}
+}
+
+// Vehicle's stats by name, nil if never seen
+func (m *Metrics) Vehicle(name string) *VehicleStats {
+	for _, s := range m.Vehicles {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Charger's stats by name, nil if never seen
+func (m *Metrics) Charger(name string) *ChargerStats {
+	for _, s := range m.Chargers {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Statuses counts the vehicles in each status as of the last tick
+func (m *Metrics) Statuses() map[string]int {
+	statuses := make(map[string]int)
+	for _, s := range m.Vehicles {
+		statuses[s.status]++
+	}
+	return statuses
+}
+
+// Summary totals the run so far
+type Summary struct {
+	Ticks       int     `json:"ticks"`
+	Hours       float64 `json:"hours"`
+	Vehicles    int     `json:"vehicles"`
+	Driving     float64 `json:"driving"` // vehicle hours
+	Waiting     float64 `json:"waiting"`
+	Charging    float64 `json:"charging"`
+	Distance    float64 `json:"distance"`
+	Visits      int     `json:"visits"`
+	Wait        float64 `json:"wait"` // average minutes a visit
+	Flats       int     `json:"flats"`
+	Chargers    int     `json:"chargers"`
+	Utilization float64 `json:"utilization"` // of every stall, percent
+	KWh         float64 `json:"kwh"`
+	Rejected    int     `json:"rejected"`
+	MaxQueue    int     `json:"maxQueue"`
+}
+
+func (m *Metrics) Summary() Summary {
+	s := Summary{Ticks: m.Ticks, Hours: m.Hours, Vehicles: len(m.Vehicles), Chargers: len(m.Chargers)}
+	for _, v := range m.Vehicles {
+		s.Driving += v.Driving
+		s.Waiting += v.Waiting
+		s.Charging += v.Charging
+		s.Distance += v.Distance
+		s.Visits += v.Visits
+		s.Flats += v.Flats
+	}
+	if s.Visits > 0 {
+		s.Wait = s.Waiting * 60 / float64(s.Visits)
+	}
+	stalls, occupied := 0.0, 0.0
+	for _, c := range m.Chargers {
+		stalls += c.Hours * float64(c.Stalls)
+		occupied += c.Occupied
+		s.KWh += c.KWh
+		s.Rejected += c.Rejected
+		if c.MaxQueue > s.MaxQueue {
+			s.MaxQueue = c.MaxQueue
+		}
+	}
+	if stalls > 0 {
+		s.Utilization = occupied / stalls * 100
+	}
+	return s
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("%d ticks, %d vehicles drove %.0f, %d flat, %d visits waiting %.1f min on average, %d turned away, %d chargers %.1f%% utilized delivering %.1f kWh",
+		s.Ticks, s.Vehicles, s.Distance, s.Flats, s.Visits, s.Wait, s.Rejected, s.Chargers, s.Utilization, s.KWh)
+}
+
+// VehicleReport writes every vehicle's stats as CSV, times in hours
+func (m *Metrics) VehicleReport(w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"vehicle", "driving", "parked", "waiting", "charging", "distance", "visits", "flats"})
+	for _, s := range m.Vehicles {
+		out.Write([]string{
+			s.Name,
+			fmt.Sprintf("%.3f", s.Driving),
+			fmt.Sprintf("%.3f", s.Parked),
+			fmt.Sprintf("%.3f", s.Waiting),
+			fmt.Sprintf("%.3f", s.Charging),
+			fmt.Sprintf("%.1f", s.Distance),
+			fmt.Sprintf("%d", s.Visits),
+			fmt.Sprintf("%d", s.Flats),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// ChargerReport writes every charger's stats as CSV
+func (m *Metrics) ChargerReport(w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"charger", "stalls", "occupancy", "kwh", "rejected", "mean_queue", "max_queue"})
+	for _, s := range m.Chargers {
+		out.Write([]string{
+			s.Name,
+			fmt.Sprintf("%d", s.Stalls),
+			fmt.Sprintf("%.1f", s.Occupancy),
+			fmt.Sprintf("%.3f", s.KWh),
+			fmt.Sprintf("%d", s.Rejected),
+			fmt.Sprintf("%.2f", s.MeanQueue),
+			fmt.Sprintf("%d", s.MaxQueue),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// statsAPI serves the metrics so far as JSON: the summary, every vehicle
+// and every charger.
+func statsAPI(world *World) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var reply []byte
+		var err error
+		done := make(chan struct{})
+		world.Do(func() {
+			defer close(done)
+			m := world.Metrics
+			reply, err = json.Marshal(struct {
+				Summary  Summary         `json:"summary"`
+				Vehicles []*VehicleStats `json:"vehicles"`
+				Chargers []*ChargerStats `json:"chargers"`
+			}{m.Summary(), m.Vehicles, m.Chargers})
+		})
+		<-done
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(reply); err != nil {
+			log.Println(err)
+		}
+	}
+}
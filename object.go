@@ -3,11 +3,13 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/rhymond/go-money"
 	"github.com/rooprob/chargesim/message"
 	uuid "github.com/satori/go.uuid"
 	"log"
 	"math"
 	"math/rand"
+	"time"
 )
 
 type Points struct {
@@ -17,9 +19,12 @@ type Points struct {
 type Hint struct {
 	TrackLength float64
 	Dist        float64
+	Climb       float64 // metres climbed on the way
+	Descent     float64 // metres descended on the way
 	Vector      float64
 	Theta       float64
 	Charger     *Charger
+	Price       int64 // per kWh now
 	Range       float64
 	InRange     bool
 	NextRange   bool
@@ -29,6 +34,7 @@ type Hint struct {
 type Object interface {
 	Points() Points
 	SetPoints(p Points)
+	SetWorld(w *World)
 	Print(prefix string) string
 	Tick()
 }
@@ -41,20 +47,44 @@ type Vehicle struct {
 	Charge              float64
 	Model, Name, Status string
 	Velocity            float64
+	Strategy            DriverStrategy
+	Books               bool // reserves a stall before heading to charge
+	BatteryTemp         float64
+	Health              float64        // share of the rated capacity left
+	MinSoC              float64        // never discharged to the grid below, percent
+	Fleet               *FleetOperator // nil for private drivers
 	points              Points
 	hints               []*Hint
+	destinations        []*Hint
+	grade               float64 // metres of height per unit travelled
+	climbAhead          float64
+	descentAhead        float64
+	world               *World
+	atHome              bool
+	dwell               time.Duration
+	destination         *Charger
+	plugged             *Charger
+	reservation         *Reservation
+	refused             *Charger // last to refuse a booking, until refusedUntil
+	refusedUntil        time.Time
+	ride                *Ride
+	sent                *Charger // by the fleet operator, to charge
 }
 
 func NewVehicle(name, model, status string, charge float64) *Vehicle {
 	return &Vehicle{
-		Id:       uuid.Must(uuid.NewV4()).String(),
-		Color:    generateColor(),
-		Kind:     message.KindVehicle,
-		Name:     name,
-		Model:    model,
-		Status:   status,
-		Velocity: 1*rand.Float64() + 0.5, // very small (<.5) is treated as zero
-		Charge:   charge,
+		Id:          uuid.Must(uuid.NewV4()).String(),
+		Color:       generateColor(),
+		Kind:        message.KindVehicle,
+		Name:        name,
+		Model:       model,
+		Status:      status,
+		Velocity:    1*rand.Float64() + 0.5, // very small (<.5) is treated as zero
+		Charge:      charge,
+		Strategy:    NearestCharger{},
+		BatteryTemp: 20.0,
+		Health:      1.0,
+		MinSoC:      50.0,
 	}
 }
 
@@ -69,6 +99,8 @@ func (v Vehicle) MarshalJSON() ([]byte, error) {
 		Name     string  `json:"name"`
 		Status   string  `json:"status"`
 		Velocity float64 `json:"velocity"`
+		Battery  float64 `json:"batteryTemp"`
+		Health   float64 `json:"health"`
 		Range    float64 `json:"range"`
 		Hints    []*Hint `json:"hints"`
 	}{
@@ -81,6 +113,8 @@ func (v Vehicle) MarshalJSON() ([]byte, error) {
 		Name:     v.Name,
 		Status:   v.Status,
 		Velocity: v.Velocity,
+		Battery:  v.BatteryTemp,
+		Health:   v.Health,
 		Range:    v.CalcRange(),
 		Hints:    v.Hints(),
 	})
@@ -94,6 +128,10 @@ func (v *Vehicle) Points() Points {
 	return v.points
 }
 
+func (v *Vehicle) SetWorld(w *World) {
+	v.world = w
+}
+
 func (v *Vehicle) SetHints(p []*Hint) {
 	v.hints = p
 }
@@ -102,16 +140,43 @@ func (v *Vehicle) Hints() []*Hint {
 	return v.hints
 }
 
+// Destination chargers, nearest first
+func (v *Vehicle) SetDestinations(p []*Hint) {
+	v.destinations = p
+}
+
+// SetTerrain passes the gradient under the vehicle in its direction of
+// travel, and the metres climbed and descended within its range ahead.
+func (v *Vehicle) SetTerrain(grade, up, down float64) {
+	v.grade = grade
+	v.climbAhead = up
+	v.descentAhead = down
+}
+
+func (v *Vehicle) Spec() *VehicleSpec {
+	return LookupVehicle(v.Model)
+}
+
 func (v *Vehicle) Tick() {
 	// tick
-	v.RouteToCharger() // may change state to Queued
+	v.Condition()
+	v.Age()
+	if v.Fleet == nil {
+		v.Schedule() // may change state to Parked, or back to Drive
+	}
+	v.RouteToDestination() // may change state to Parked
+	v.RouteToCharger()     // may change state to Queued
 
 	switch v.Status {
 	case "drive":
 		v.Drive()
 		break
 	case "parked":
-		// do nothing
+		if v.atHome {
+			v.HomeCharge()
+		} else if v.Fleet == nil {
+			v.Dwell()
+		}
 		break
 	case "queued":
 		// do nothing
@@ -127,17 +192,23 @@ func (v *Vehicle) Tick() {
 
 // State setting
 func (v *Vehicle) Flat() {
-	v.Status = "flat"
+	if v.Status != "flat" {
+		v.world.Event(Event{Kind: FlatEvent, Vehicle: v.Name, Charge: v.Charge})
+	}
+	v.status("flat")
 	v.Velocity = 0.0
 	v.Charge = 0.0
 }
 
 func (v *Vehicle) Drive() {
-	v.Status = "drive"
+	v.status("drive")
 
 	// intial speed
 	if v.Velocity == 0.0 {
-		v.Velocity = 1.0 * v.hints[0].Vector
+		v.Velocity = 1.0
+		if len(v.hints) > 0 {
+			v.Velocity = v.hints[0].Vector
+		}
 	}
 
 	// if we can make it, speed up
@@ -161,30 +232,197 @@ func (v *Vehicle) Drive() {
 }
 
 func (v *Vehicle) Charging() {
-	v.Status = "charging"
-	v.Charge = v.Charge + (100 * 0.01)
-	if v.Charge > 99.0 {
-		v.Drive()
-	}
+	v.status("charging")
 }
 
 func (v *Vehicle) Queued() {
-	v.Status = "queued"
+	v.status("queued")
 	v.Velocity = 0.0
 }
 
 func (v *Vehicle) Parked() {
-	v.Status = "parked"
+	v.status("parked")
 	v.Velocity = 0.0
 }
 
+// status changes to next, logged if it's a change
+func (v *Vehicle) status(next string) {
+	if v.Status != next {
+		v.world.Event(Event{Kind: StatusEvent, Vehicle: v.Name, From: v.Status, To: next, Charge: v.Charge})
+	}
+	v.Status = next
+}
+
+// Schedule applies the time of day to the driver: heading home as traffic
+// dies down, and setting out again as demand picks up.
+func (v *Vehicle) Schedule() {
+	if v.world == nil {
+		return
+	}
+	d := v.world.Demand
+	now := v.world.Clock.Now()
+
+	switch v.Status {
+	case "drive":
+		if v.destination != nil {
+			// on the way to a stop already
+			break
+		}
+		if v.world.Chance(d.ParkRate * d.Park.Factor(now)) {
+			v.Parked()
+			v.atHome = true
+		} else if v.world.Chance(d.StopRate * d.Stop.Factor(now)) {
+			v.Stop(v.world.Dwell(d.Dwell))
+		}
+	case "parked":
+		if v.atHome && v.world.Chance(d.DepartRate*d.Spawn.Factor(now)) {
+			v.atHome = false
+			v.Velocity = 1*v.world.Rand.Float64() + 0.5
+			v.Drive()
+		}
+	}
+}
+
+// HomeCharge tops up a vehicle parked at home, scaled by the share of
+// drivers plugged in at this hour.
+func (v *Vehicle) HomeCharge() {
+	if !v.atHome || v.world == nil {
+		return
+	}
+	d := v.world.Demand
+	now := v.world.Clock.Now()
+	pct := d.HomeChargeRate * d.HomeCharging.Factor(now) * v.world.Clock.Hours()
+	v.Wear(v.Energize(pct/100*v.Capacity()), false)
+}
+
+// Stop parks at a destination for dwell. Drivers wanting a charge pick a
+// destination with a charger when there is one within a short detour.
+func (v *Vehicle) Stop(dwell time.Duration) {
+	v.dwell = dwell
+
+	// paid to plug in to the grid whatever the charge
+	if v.world.V2G != nil && v.Spec().V2GKW > 0 {
+		for _, h := range v.destinations {
+			if h.Charger.V2GKW > 0 && h.Dist < DetourDistance {
+				v.destination = h.Charger
+				return
+			}
+		}
+	}
+	if len(v.destinations) > 0 && v.Charge < v.world.Demand.PlugBelow {
+		if v.destinations[0].Dist < DetourDistance {
+			v.destination = v.destinations[0].Charger
+			return
+		}
+	}
+	v.Parked()
+}
+
+// RouteToDestination heads for the chosen destination charger, parking
+// and plugging in on arrival.
+func (v *Vehicle) RouteToDestination() {
+	if v.Status != "drive" || v.destination == nil {
+		return
+	}
+	for _, h := range v.destinations {
+		if h.Charger != v.destination {
+			continue
+		}
+		if math.Signbit(h.Vector) != math.Signbit(v.Velocity) {
+			v.Velocity = v.Velocity * -1 // turn around
+		}
+		if h.Dist < 1.0 {
+			v.destination = nil
+			v.Parked()
+			h.Charger.Plug(v) // park anyway if the stalls are taken
+		}
+		return
+	}
+	v.destination = nil // gone offline, park and stop as usual
+}
+
+// Dwell counts down a destination stop, then unplugs and drives on.
+func (v *Vehicle) Dwell() {
+	v.dwell -= v.world.Clock.Step
+	if v.dwell > 0 {
+		return
+	}
+	if v.plugged != nil {
+		v.plugged.Unplug(v)
+	}
+	v.Velocity = 1*v.world.Rand.Float64() + 0.5
+	v.Drive()
+}
+
+// Energize adds kWh to the battery, returning what it could accept.
+func (v *Vehicle) Energize(kWh float64) float64 {
+	capacity := v.Capacity()
+	room := (100.0 - v.Charge) / 100 * capacity
+	if kWh >= room {
+		v.Charge = 100.0
+		return math.Max(room, 0.0)
+	}
+	v.Charge = v.Charge + kWh/capacity*100
+	return kWh
+}
+
+// Book a stall at the chosen charger for arriving soon, moving any
+// booking held elsewhere.
+func (v *Vehicle) Book(h *Hint) {
+	if v.world == nil {
+		return
+	}
+	if r := v.reservation; r != nil && r.Status == "booked" {
+		if r.Charger == h.Charger {
+			return
+		}
+		r.Charger.Cancel(r)
+	}
+	v.reservation = nil
+
+	now := v.world.Clock.Now()
+	if h.Charger == v.refused && now.Before(v.refusedUntil) {
+		return
+	}
+	ticks := h.Dist / math.Max(math.Abs(v.Velocity), 0.1)
+	eta := time.Duration(ticks * float64(v.world.Clock.Step))
+	r, err := h.Charger.Reserve(v.Name, now, now.Add(eta+ReservationGrace))
+	if err != nil {
+		v.world.Event(Event{Kind: RefuseEvent, Vehicle: v.Name, Charger: h.Charger.Name, Reason: err.Error()})
+		v.refused, v.refusedUntil = h.Charger, now.Add(RefusalBackoff)
+		return
+	}
+	v.refused = nil
+	v.reservation = r
+}
+
+// Capacity is the usable battery capacity in kWh, after any wear
+func (v *Vehicle) Capacity() float64 {
+	return v.Spec().CapacityKWh * v.Health
+}
+
+// Wear the battery for kWh charged, when the World models degradation
+func (v *Vehicle) Wear(kWh float64, fast bool) {
+	if v.world == nil || v.world.Degradation == nil || kWh == 0 {
+		return
+	}
+	v.Health = v.world.Degradation.Cycle(v.Health, kWh, v.Spec().CapacityKWh, fast)
+}
+
+// Age the battery for the time spent this tick
+func (v *Vehicle) Age() {
+	if v.world == nil || v.world.Degradation == nil {
+		return
+	}
+	v.Health = v.world.Degradation.Age(v.Health, v.Charge, v.world.Clock.Hours())
+}
+
 func (v *Vehicle) EcoMode() {
 	if math.Abs(v.Velocity) < 0.2 {
 		v.Velocity = v.Velocity * 1.19
 	} else if math.Abs(v.Velocity) > 0.4 {
 		v.Velocity = v.Velocity * 0.9
 	}
-	fmt.Printf("ECO mode: %.2f\n", v.Velocity)
 }
 
 // Process Hints data to determine whether the stop and recharge, or go on.
@@ -193,41 +431,83 @@ func (v *Vehicle) RouteToCharger() {
 	if v.Status != "drive" {
 		return
 	}
+	if v.Fleet != nil {
+		// the operator decides when to charge
+		v.RouteToSent()
+		return
+	}
 
-	if len(v.hints) > 1 {
-		// we can make it to the charger after nearest
-		if v.hints[1].InRange {
-			return
+	// the driver's choice, if it's time to charge
+	h := v.Strategy.Choose(v, v.hints)
+	if h == nil {
+		return
+	}
+	if v.Books {
+		v.Book(h)
+	}
+	if math.Signbit(h.Vector) != math.Signbit(v.Velocity) {
+		v.Velocity = v.Velocity * -1  // turn around
+		v.Velocity = v.Velocity * 0.5 // slow down to turn around
+	}
+
+	// Snap to a Charger and queue up (if queue not already full!)
+	if h.Dist < 1.0 {
+		h.Charger.Add(v)
+	}
+}
+
+// RouteToSent heads for the charger the fleet operator sent v to, joining
+// its queue on arrival.
+func (v *Vehicle) RouteToSent() {
+	if v.sent == nil {
+		return
+	}
+	for _, h := range v.hints {
+		if h.Charger != v.sent {
+			continue
 		}
-	} else if len(v.hints) == 1 {
-		// we can make it all the way around!
-		if v.hints[0].NextRange {
-			return
+		if math.Signbit(h.Vector) != math.Signbit(v.Velocity) {
+			v.Velocity = v.Velocity * -1 // turn around
 		}
-		// we can make it!
-		if v.hints[0].InRange {
-			return
+		if h.Dist < 1.0 {
+			v.sent = nil
+			h.Charger.Add(v)
 		}
-	} else if len(v.hints) == 0 {
-		// oh dear, keeping going...
 		return
 	}
+	v.sent = nil // gone offline, the operator will find another
+}
 
-	// Take the Hint
-	// We wont make it to the next charger, so head to nearest
-	if math.Signbit(v.hints[0].Vector) != math.Signbit(v.Velocity) {
-		v.Velocity = v.Velocity * -1  // turn around
-		v.Velocity = v.Velocity * 0.5 // slow down to turn around
+// Condition drifts the battery toward the ambient temperature, running
+// warmer while driving or charging.
+func (v *Vehicle) Condition() {
+	if v.world == nil {
+		return
 	}
+	target := v.world.Temperature()
+	if v.Status == "drive" || v.Status == "charging" {
+		target = target + BatteryWarming
+	}
+	k := math.Min(1.0, v.world.Clock.Hours()/BatteryLag.Hours())
+	v.BatteryTemp = v.BatteryTemp + (target-v.BatteryTemp)*k
+}
 
-	// Snap to a Charger and queue up (if queue not already full!)
-	if v.hints[0].Dist < 1.0 {
-		v.hints[0].Charger.Add(v)
+// climate scales consumption for the weather
+func (v *Vehicle) climate() float64 {
+	if v.world == nil {
+		return 1.0
 	}
+	return v.Spec().Climate(v.world.Temperature())
 }
 
 func (v *Vehicle) CalcRange() float64 {
-	if v.Charge < 0.1 {
+	return v.RangeOver(v.climbAhead, v.descentAhead)
+}
+
+// RangeOver is the range left after climbing up and descending down metres
+func (v *Vehicle) RangeOver(up, down float64) float64 {
+	charge := math.Min(100.0, v.Charge-v.TerrainCost(up, down))
+	if charge < 0.1 {
 		return 0.0
 	}
 	var consumption float64
@@ -237,12 +517,26 @@ func (v *Vehicle) CalcRange() float64 {
 		// XXX refactor with Consume()
 		consumption = math.Abs(v.Velocity) / 10
 	}
-	return (v.Charge / consumption)
+	consumption = consumption * v.climate() / v.Health
+	return (charge / consumption)
+}
+
+// TerrainCost is the charge used climbing up metres, less what
+// regenerative braking recovers descending down metres.
+func (v *Vehicle) TerrainCost(up, down float64) float64 {
+	regen := 0.0
+	if v.world != nil {
+		regen = v.world.Regen
+	}
+	kWh := v.Spec().MassKg * Gravity * (up - regen*down) / 3.6e6
+	return kWh / v.Capacity() * 100
 }
 
 func (v *Vehicle) Consume() {
 	// XXX refactor with CalcRange()
-	v.Charge = v.Charge - (math.Abs(v.Velocity) / 10)
+	dh := v.grade * math.Abs(v.Velocity)
+	terrain := v.TerrainCost(math.Max(dh, 0), math.Max(-dh, 0))
+	v.Charge = math.Min(100.0, v.Charge-(math.Abs(v.Velocity)/10*v.climate()/v.Health)-terrain)
 	if v.Charge < 0.1 {
 		v.Flat()
 	}
@@ -260,6 +554,18 @@ func (v *Vehicle) String() string {
 	return v.Print("/")
 }
 
+// how far a driver will go out of their way for a destination charger
+const DetourDistance = 60.0
+
+// m/s^2
+const Gravity = 9.81
+
+// Battery temperature above ambient in use, and how quickly it gets there
+const (
+	BatteryWarming = 10.0
+	BatteryLag     = 2 * time.Hour
+)
+
 // A Charger
 type Charger struct {
 	Id                  string
@@ -267,30 +573,83 @@ type Charger struct {
 	Color               string
 	points              Points
 	Model, Name, Status string
+	AC                  bool
+	Power               float64 // kW
+	V2GKW               float64 // kW, discharging to the grid
+	Stalls              int
+	QueueCapacity       int
+	Site                string
+	Tariff              *Tariff
+	Pricing             PricingPolicy
+	Revenue             *money.Money
+	Delivered           float64 // kWh, over the run
+	Rejected            int     // vehicles turned away by a full queue
 	queue               []*Vehicle
+	sessions            map[*Vehicle]*Session
+	reservations        []*Reservation
+	reserved            map[*Vehicle]bool // arrived on a booking
+	turned              map[*Vehicle]int  // tick last turned away
+	Fault               string            // outage, derated or payment
+	MTBF, MTTR          time.Duration
+	repairLeft          time.Duration
+	crew                *Crew
+	Unavailable         bool // taken out of service
+	Remote              bool // an OCPP charge point outside the simulation
+	point               *ChargePoint
+	world               *World
 }
 
 func NewCharger(name, model, status string) *Charger {
-	return &Charger{
-		Id:     uuid.Must(uuid.NewV4()).String(),
-		Color:  "#00ff00",
-		Kind:   message.KindCharger,
-		Name:   name,
-		Model:  model,
-		Status: status,
+	spec := LookupCharger(model)
+	c := &Charger{
+		Id:            uuid.Must(uuid.NewV4()).String(),
+		Color:         "#00ff00",
+		Kind:          message.KindCharger,
+		Name:          name,
+		Model:         model,
+		Status:        status,
+		AC:            spec.AC,
+		Power:         spec.PowerKW,
+		V2GKW:         spec.V2GKW,
+		Stalls:        spec.Stalls,
+		MTBF:          spec.MTBF,
+		MTTR:          spec.MTTR,
+		QueueCapacity: 3,
+		Site:          name,
+		Tariff:        NewTariff("USD", 45),
+		Pricing:       FlatPricing{},
+		Revenue:       money.New(0, "USD"),
+		sessions:      make(map[*Vehicle]*Session),
+		reserved:      make(map[*Vehicle]bool),
+		turned:        make(map[*Vehicle]int),
+	}
+	if c.AC {
+		c.Color = "#0088ff"
+		c.QueueCapacity = c.Stalls
+		c.Tariff = NewTariff("USD", 30)
+		c.Tariff.IdleFee = 40
 	}
+	return c
 }
 
 func (c *Charger) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Id          string `json:"id"`
-		Kind        int    `json:"kind"`
-		Color       string `json:"color"`
-		Points      Points `json:"points"`
-		Model       string `json:"model"`
-		Name        string `json:"name"`
-		Status      string `json:"status"`
-		QueueLength int    `json:"queueLength"`
+		Id          string  `json:"id"`
+		Kind        int     `json:"kind"`
+		Color       string  `json:"color"`
+		Points      Points  `json:"points"`
+		Model       string  `json:"model"`
+		Name        string  `json:"name"`
+		Status      string  `json:"status"`
+		QueueLength int     `json:"queueLength"`
+		Reserved    int     `json:"reserved"`
+		AC          bool    `json:"ac"`
+		Power       float64 `json:"power"`
+		V2G         float64 `json:"v2g"`
+		Site        string  `json:"site"`
+		Price       int64   `json:"price"`
+		Currency    string  `json:"currency"`
+		Revenue     string  `json:"revenue"`
 	}{
 		Id:          c.Id,
 		Kind:        c.Kind,
@@ -300,25 +659,176 @@ func (c *Charger) MarshalJSON() ([]byte, error) {
 		Name:        c.Name,
 		Status:      c.Status,
 		QueueLength: len(c.Queue()),
+		Reserved:    c.held(),
+		AC:          c.AC,
+		Power:       c.Power,
+		V2G:         c.V2GKW,
+		Site:        c.Site,
+		Price:       c.Price().PerKWh,
+		Currency:    c.Price().Currency,
+		Revenue:     c.Revenue.Display(),
 	})
 }
 
 // Adds an element to the tree branch
 func (c *Charger) Add(child *Vehicle) {
 
-	if len(c.queue) >= 3 {
+	if c.AC || !c.Online() {
+		return
+	}
+	if r := c.reservationFor(child); r != nil {
+		r.Status = "arrived"
+		c.reserved[child] = true
+		c.queue = c.insertBooked(child)
+		c.world.Event(Event{Kind: JoinEvent, Vehicle: child.Name, Charger: c.Name, Charge: child.Charge, Booked: true})
+		child.Queued()
+		return
+	}
+	if len(c.queue)+c.held() >= c.QueueCapacity {
+		// once a visit, however many ticks it tries
+		tick := c.world.Clock.Ticks()
+		if last, ok := c.turned[child]; !ok || last < tick-1 {
+			c.Rejected++
+			c.world.Event(Event{Kind: RejectEvent, Vehicle: child.Name, Charger: c.Name, Charge: child.Charge})
+		}
+		c.turned[child] = tick
 		return
 	}
-	fmt.Println("adding to Queue")
 	c.queue = append(c.queue, child)
+	c.world.Event(Event{Kind: JoinEvent, Vehicle: child.Name, Charger: c.Name, Charge: child.Charge})
 	child.Queued()
 }
 
+// insertBooked places v ahead of any walk-ins waiting, behind the vehicle
+// charging and those booked before it.
+func (c *Charger) insertBooked(v *Vehicle) []*Vehicle {
+	i := 0
+	if len(c.queue) > 0 && c.sessions[c.queue[0]] != nil {
+		i = 1
+	}
+	for i < len(c.queue) && c.reserved[c.queue[i]] {
+		i++
+	}
+	queue := append([]*Vehicle{}, c.queue[:i]...)
+	queue = append(queue, v)
+	return append(queue, c.queue[i:]...)
+}
+
+// Plug a parked vehicle in to a free stall of a destination charger
+func (c *Charger) Plug(v *Vehicle) bool {
+	if !c.AC || !c.Online() {
+		return false
+	}
+	if r := c.reservationFor(v); r != nil {
+		r.Status = "arrived"
+	} else if len(c.queue)+c.held() >= c.QueueCapacity {
+		return false
+	}
+	c.queue = append(c.queue, v)
+	c.StartSession(v)
+	v.plugged = c
+	return true
+}
+
+// Unplug a vehicle, billing its session
+func (c *Charger) Unplug(v *Vehicle) {
+	c.remove(v)
+	c.EndSession(v)
+	v.plugged = nil
+}
+
+// remove v from the queue
+func (c *Charger) remove(v *Vehicle) {
+	for i, q := range c.queue {
+		if q == v {
+			c.queue = append(c.queue[:i], c.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// StartSession opens a billing session for v, if not already open
+func (c *Charger) StartSession(v *Vehicle) {
+	if _, ok := c.sessions[v]; ok {
+		return
+	}
+	t := c.Price()
+	s := &Session{
+		Id:       uuid.Must(uuid.NewV4()).String(),
+		Charger:  c,
+		Vehicle:  v,
+		Start:    c.world.Clock.Now(),
+		Currency: t.Currency,
+		Amount:   float64(t.SessionFee),
+	}
+	c.sessions[v] = s
+	if c.point != nil {
+		c.point.Start(v)
+	}
+	c.world.Event(Event{Kind: StartEvent, Vehicle: v.Name, Charger: c.Name, Charge: v.Charge, Session: s.Id})
+}
+
+// EndSession closes and bills the session for v
+func (c *Charger) EndSession(v *Vehicle) {
+	s, ok := c.sessions[v]
+	if !ok {
+		return
+	}
+	delete(c.sessions, v)
+	if c.point != nil {
+		c.point.Stop(v)
+	}
+	s.End = c.world.Clock.Now()
+	t := c.world.Billing.Close(s)
+	if revenue, err := c.Revenue.Add(t.Amount); err == nil {
+		c.Revenue = revenue
+	} else if c.Revenue.IsZero() {
+		c.Revenue = t.Amount // nothing taken yet, count in the tariff's currency
+	} else {
+		log.Printf("charger %s: %v", c.Name, err)
+	}
+	c.world.Event(Event{Kind: StopEvent, Vehicle: v.Name, Charger: c.Name, Charge: v.Charge, Session: s.Id, KWh: s.KWh})
+	c.world.Event(Event{Kind: TransactionEvent, Vehicle: v.Name, Charger: c.Name, Session: s.Id,
+		KWh: s.KWh, Amount: t.Amount.Amount(), Credit: t.Credit.Amount()})
+}
+
+// meter records a tick of v's session, kWh delivered
+func (c *Charger) meter(v *Vehicle, kWh float64) {
+	s, ok := c.sessions[v]
+	if !ok {
+		return
+	}
+	s.KWh += kWh
+	c.Delivered += kWh
+	if c.point != nil {
+		c.point.Meter(v, kWh)
+	}
+	s.Amount += c.Price().Cost(kWh, c.world.Clock.Step)
+	if kWh > 0 {
+		s.Charging += c.world.Clock.Step
+	} else {
+		s.Idle += c.world.Clock.Step
+	}
+}
+
 // Returns the child elements
 func (c *Charger) Queue() []*Vehicle {
 	return c.queue
 }
 
+// Price is the tariff on offer now
+func (c *Charger) Price() *Tariff {
+	if c.Pricing == nil || c.world == nil {
+		return c.Tariff
+	}
+	return c.Pricing.Tariff(c, c.world.Clock.Now())
+}
+
+// Occupancy is the share of the queue, or stalls, in use
+func (c *Charger) Occupancy() float64 {
+	return float64(len(c.queue)) / float64(c.QueueCapacity)
+}
+
 func (c *Charger) SetPoints(p Points) {
 	c.points = p
 }
@@ -327,21 +837,83 @@ func (c *Charger) Points() Points {
 	return c.points
 }
 
+func (c *Charger) SetWorld(w *World) {
+	c.world = w
+	if w != nil {
+		w.Site(c.Site) // open for accounts, even if never used
+	}
+}
+
 func (c *Charger) ProcessQueue() {
+	if c.AC {
+		c.ProcessStalls()
+		return
+	}
 	if len(c.queue) > 0 {
+		head := c.queue[0]
+		if c.sessions[head] == nil && !c.Online() {
+			// out of service, no new sessions
+			return
+		}
+		if c.sessions[head] == nil && !c.reserved[head] && c.held() > 0 {
+			// stall held for a booking, walk-ins wait
+			return
+		}
 		if c.queue[0].Charge < 100 {
 			c.queue[0].Charging()
+			c.StartSession(c.queue[0])
+			c.meter(c.queue[0], c.Deliver(c.queue[0]))
 		} else {
 			c.queue[0].Drive()
+			c.EndSession(c.queue[0])
+			delete(c.reserved, c.queue[0])
 			_, c.queue = c.queue[0], c.queue[1:]
 		}
 	}
 }
 
+// ProcessStalls charges every plugged in vehicle, or discharges them
+// while the grid is paying for it.
+func (c *Charger) ProcessStalls() {
+	for _, v := range c.queue {
+		c.Offer(v)
+		if price, ok := c.Dispatching(v); ok {
+			c.export(v, c.Discharge(v), price)
+			continue
+		}
+		c.meter(v, c.Deliver(v))
+	}
+}
+
+// Deliver charges v for a tick at the lower of the charger and vehicle
+// limits, reduced for a cold battery. Returns the kWh delivered.
+func (c *Charger) Deliver(v *Vehicle) float64 {
+	spec := v.Spec()
+	limit := spec.MaxDCKW
+	if c.AC {
+		limit = spec.MaxACKW
+	}
+	kW := math.Min(c.Power, limit) * spec.Acceptance(v.BatteryTemp)
+	if c.Fault == FaultDerated {
+		kW *= DeratedShare
+	}
+	site := c.world.Site(c.Site)
+	kW = site.Allocate(kW, c.world.Clock.Now(), c.world.Clock.Hours())
+	kWh := v.Energize(kW * c.world.Clock.Hours())
+	v.Wear(kWh, !c.AC)
+	site.Draw(kWh)
+	return kWh
+}
+
 func (c *Charger) Tick() {
 	// lifecycle event
+	c.Reliability()
+	c.ExpireReservations()
 	// process queue
 	c.ProcessQueue()
+	if c.point != nil {
+		c.point.Tick()
+	}
 	// increase/decrease random amount
 }
 
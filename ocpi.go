@@ -0,0 +1,520 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rhymond/go-money"
+	"github.com/rooprob/chargesim/message"
+	"github.com/rooprob/chargesim/ocpi"
+)
+
+// OCPIPageLimit is the most objects a page holds, and the default
+const OCPIPageLimit = 50
+
+// OCPI serves a read-only API over the simulation modelled on the OCPI
+// 2.2 Locations, Sessions, CDRs and Tariffs modules, as a charge point
+// operator would to roaming platforms. Sites are locations, each stall an
+// EVSE; completed transactions are kept as CDRs.
+type OCPI struct {
+	CountryCode string
+	PartyId     string
+	City        string
+	Country     string
+	Latitude    float64 // of the track origin, pixels are ~10m
+	Longitude   float64
+	TimeZone    string
+	track       Track
+	world       *World
+	mu          sync.Mutex
+	cdrs        []*ocpi.CDR
+}
+
+func NewOCPI(t Track, w *World) *OCPI {
+	o := &OCPI{
+		CountryCode: "US",
+		PartyId:     "CSM",
+		City:        "San Francisco",
+		Country:     "USA",
+		Latitude:    37.7749,
+		Longitude:   -122.4194,
+		TimeZone:    "America/Los_Angeles",
+		track:       t,
+		world:       w,
+	}
+	w.Billing.OnTransaction(o.onTransaction)
+	return o
+}
+
+// Handle the OCPI modules on mux, under /ocpi
+func (o *OCPI) Handle(mux *http.ServeMux) {
+	mux.HandleFunc("/ocpi/versions", o.handleVersions)
+	mux.HandleFunc("/ocpi/"+ocpi.Version, o.handleVersionDetails)
+	mux.HandleFunc("/ocpi/"+ocpi.Version+"/locations", o.handleLocations)
+	mux.HandleFunc("/ocpi/"+ocpi.Version+"/locations/", o.handleLocation)
+	mux.HandleFunc("/ocpi/"+ocpi.Version+"/sessions", o.handleSessions)
+	mux.HandleFunc("/ocpi/"+ocpi.Version+"/cdrs", o.handleCDRs)
+	mux.HandleFunc("/ocpi/"+ocpi.Version+"/tariffs", o.handleTariffs)
+}
+
+// paged is an object to list, and when it last changed
+type paged struct {
+	updated time.Time
+	object  interface{}
+}
+
+// inSim runs f in the simulation's own time, waiting on it
+func (o *OCPI) inSim(f func()) {
+	done := make(chan struct{})
+	o.world.Do(func() {
+		f()
+		close(done)
+	})
+	<-done
+}
+
+func (o *OCPI) reply(w http.ResponseWriter, status, code int, data interface{}, msg string, now time.Time) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(&ocpi.Response{
+		Data:          data,
+		StatusCode:    code,
+		StatusMessage: msg,
+		Timestamp:     now,
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// page replies with the objects updated from date_from until date_to,
+// offset and limited, linking to the next page as the spec does.
+func (o *OCPI) page(w http.ResponseWriter, r *http.Request, objects []paged, now time.Time) {
+	q := r.URL.Query()
+	offset, limit := 0, OCPIPageLimit
+	var from, to time.Time
+	var err error
+	if v := q.Get("offset"); v != "" && err == nil {
+		offset, err = strconv.Atoi(v)
+	}
+	if v := q.Get("limit"); v != "" && err == nil {
+		limit, err = strconv.Atoi(v)
+	}
+	if v := q.Get("date_from"); v != "" && err == nil {
+		from, err = time.Parse(time.RFC3339, v)
+	}
+	if v := q.Get("date_to"); v != "" && err == nil {
+		to, err = time.Parse(time.RFC3339, v)
+	}
+	if err != nil || offset < 0 || limit < 0 {
+		o.reply(w, http.StatusBadRequest, ocpi.InvalidParams, nil, "invalid parameters", now)
+		return
+	}
+	if limit > OCPIPageLimit {
+		limit = OCPIPageLimit
+	}
+
+	matched := make([]interface{}, 0, len(objects))
+	for _, p := range objects {
+		if (!from.IsZero() && p.updated.Before(from)) || (!to.IsZero() && !p.updated.Before(to)) {
+			continue
+		}
+		matched = append(matched, p.object)
+	}
+	total := len(matched)
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	w.Header().Set("X-Limit", strconv.Itoa(OCPIPageLimit))
+	if end < total {
+		next := *r.URL
+		q.Set("offset", strconv.Itoa(end))
+		q.Set("limit", strconv.Itoa(limit))
+		next.RawQuery = q.Encode()
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", o.absolute(r, &next)))
+	}
+	o.reply(w, http.StatusOK, ocpi.Success, matched[offset:end], "", now)
+}
+
+// absolute URL of u, as requested of r
+func (o *OCPI) absolute(r *http.Request, u *url.URL) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + u.RequestURI()
+}
+
+func (o *OCPI) handleVersions(w http.ResponseWriter, r *http.Request) {
+	u := &url.URL{Path: "/ocpi/" + ocpi.Version}
+	o.reply(w, http.StatusOK, ocpi.Success, []ocpi.VersionInfo{
+		{Version: ocpi.Version, URL: o.absolute(r, u)},
+	}, "", time.Now())
+}
+
+func (o *OCPI) handleVersionDetails(w http.ResponseWriter, r *http.Request) {
+	details := &ocpi.VersionDetails{Version: ocpi.Version}
+	for _, module := range []string{"locations", "sessions", "cdrs", "tariffs"} {
+		u := &url.URL{Path: "/ocpi/" + ocpi.Version + "/" + module}
+		details.Endpoints = append(details.Endpoints, ocpi.Endpoint{
+			Identifier: module,
+			Role:       "SENDER",
+			URL:        o.absolute(r, u),
+		})
+	}
+	o.reply(w, http.StatusOK, ocpi.Success, details, "", time.Now())
+}
+
+func (o *OCPI) handleLocations(w http.ResponseWriter, r *http.Request) {
+	var objects []paged
+	var now time.Time
+	o.inSim(func() {
+		now = o.world.Clock.Now()
+		for _, l := range o.locations() {
+			objects = append(objects, paged{l.LastUpdated, l})
+		}
+	})
+	o.page(w, r, objects, now)
+}
+
+// handleLocation replies with a location, or one of its EVSEs or their
+// connectors: /locations/{location_id}[/{evse_uid}[/{connector_id}]]
+func (o *OCPI) handleLocation(w http.ResponseWriter, r *http.Request) {
+	ids := strings.Split(strings.TrimPrefix(r.URL.Path, "/ocpi/"+ocpi.Version+"/locations/"), "/")
+	var found interface{}
+	var now time.Time
+	o.inSim(func() {
+		now = o.world.Clock.Now()
+		for _, l := range o.locations() {
+			if l.Id != ids[0] {
+				continue
+			}
+			if len(ids) == 1 {
+				found = l
+				return
+			}
+			for _, e := range l.EVSEs {
+				if e.UID != ids[1] {
+					continue
+				}
+				if len(ids) == 2 {
+					found = e
+					return
+				}
+				for _, c := range e.Connectors {
+					if c.Id == ids[2] && len(ids) == 3 {
+						found = c
+						return
+					}
+				}
+			}
+		}
+	})
+	if found == nil {
+		o.reply(w, http.StatusNotFound, ocpi.UnknownLocation, nil, "unknown location", now)
+		return
+	}
+	o.reply(w, http.StatusOK, ocpi.Success, found, "", now)
+}
+
+func (o *OCPI) handleSessions(w http.ResponseWriter, r *http.Request) {
+	var objects []paged
+	var now time.Time
+	o.inSim(func() {
+		now = o.world.Clock.Now()
+		for _, c := range o.chargers() {
+			for i, v := range c.queue {
+				s, ok := c.sessions[v]
+				if !ok {
+					continue
+				}
+				evse := o.evseUID(c, i)
+				objects = append(objects, paged{now, &ocpi.Session{
+					CountryCode:   o.CountryCode,
+					PartyId:       o.PartyId,
+					Id:            s.Id,
+					StartDateTime: s.Start,
+					KWh:           s.KWh,
+					CdrToken:      o.token(v.Id),
+					AuthMethod:    ocpi.AuthCommand,
+					LocationId:    c.Site,
+					EvseUID:       evse,
+					ConnectorId:   "1",
+					Currency:      s.Currency,
+					TotalCost:     &ocpi.Price{ExclVat: majorUnits(int64(s.Amount), s.Currency)},
+					Status:        ocpi.SessionActive,
+					LastUpdated:   now,
+				}})
+			}
+		}
+	})
+	o.page(w, r, objects, now)
+}
+
+func (o *OCPI) handleCDRs(w http.ResponseWriter, r *http.Request) {
+	var now time.Time
+	o.inSim(func() {
+		now = o.world.Clock.Now()
+	})
+	o.mu.Lock()
+	objects := make([]paged, len(o.cdrs))
+	for i, cdr := range o.cdrs {
+		objects[i] = paged{cdr.LastUpdated, cdr}
+	}
+	o.mu.Unlock()
+	o.page(w, r, objects, now)
+}
+
+func (o *OCPI) handleTariffs(w http.ResponseWriter, r *http.Request) {
+	var objects []paged
+	var now time.Time
+	o.inSim(func() {
+		now = o.world.Clock.Now()
+		for _, c := range o.chargers() {
+			objects = append(objects, paged{now, o.tariff(c, now)})
+		}
+	})
+	o.page(w, r, objects, now)
+}
+
+// chargers on the track, in order
+func (o *OCPI) chargers() []*Charger {
+	var chargers []*Charger
+	for _, val := range o.track.Childs() {
+		if c, ok := val.(*Charger); ok {
+			chargers = append(chargers, c)
+		}
+	}
+	return chargers
+}
+
+// locations, one for each site in the order first seen, with an EVSE for
+// every stall.
+func (o *OCPI) locations() []*ocpi.Location {
+	now := o.world.Clock.Now()
+	var locations []*ocpi.Location
+	bySite := make(map[string]*ocpi.Location)
+	for _, c := range o.chargers() {
+		l, ok := bySite[c.Site]
+		if !ok {
+			l = &ocpi.Location{
+				CountryCode: o.CountryCode,
+				PartyId:     o.PartyId,
+				Id:          c.Site,
+				Publish:     true,
+				Name:        c.Site,
+				Address:     "Track " + c.Site,
+				City:        o.City,
+				Country:     o.Country,
+				Coordinates: o.coordinates(c),
+				TimeZone:    o.TimeZone,
+				LastUpdated: now,
+			}
+			bySite[c.Site] = l
+			locations = append(locations, l)
+		}
+		for i := 0; i < c.Stalls; i++ {
+			coordinates := o.coordinates(c)
+			l.EVSEs = append(l.EVSEs, &ocpi.EVSE{
+				UID:         o.evseUID(c, i),
+				EvseId:      o.evseId(c, i),
+				Status:      o.evseStatus(c, i),
+				Connectors:  []*ocpi.Connector{o.connector(c, now)},
+				Coordinates: &coordinates,
+				LastUpdated: now,
+			})
+		}
+	}
+	return locations
+}
+
+func (o *OCPI) evseUID(c *Charger, stall int) string {
+	return fmt.Sprintf("%s-%d", c.Id, stall+1)
+}
+
+// evseId in the eMI3 form, country*operator*Eid
+func (o *OCPI) evseId(c *Charger, stall int) string {
+	return fmt.Sprintf("%s*%s*E%s%d", o.CountryCode, o.PartyId, c.Name, stall+1)
+}
+
+// evseStatus of a stall: those charging first, then those held for
+// bookings.
+func (o *OCPI) evseStatus(c *Charger, stall int) string {
+	switch {
+	case c.Fault == FaultOutage || c.Fault == FaultPayment:
+		return ocpi.OutOfOrder
+	case c.Unavailable:
+		return ocpi.Inoperative
+	}
+	charging := len(c.sessions)
+	if !c.AC && len(c.queue) > 0 {
+		charging = 1
+	}
+	switch {
+	case stall < charging:
+		return ocpi.Charging
+	case stall < charging+c.held():
+		return ocpi.Reserved
+	}
+	return ocpi.Available
+}
+
+func (o *OCPI) connector(c *Charger, now time.Time) *ocpi.Connector {
+	conn := &ocpi.Connector{
+		Id:               "1",
+		Standard:         ocpi.StandardCCS2,
+		Format:           ocpi.FormatCable,
+		PowerType:        ocpi.PowerDC,
+		MaxVoltage:       500,
+		MaxElectricPower: int(c.Power * 1000),
+		TariffIds:        []string{c.Id},
+		LastUpdated:      now,
+	}
+	if c.AC {
+		conn.Standard = ocpi.StandardType2
+		conn.Format = ocpi.FormatSocket
+		conn.PowerType = ocpi.PowerAC3Phase
+		conn.MaxVoltage = 230
+	}
+	conn.MaxAmperage = int(c.Power * 1000 / float64(conn.MaxVoltage))
+	return conn
+}
+
+func (o *OCPI) coordinates(c *Charger) ocpi.GeoLocation {
+	p := c.Points()
+	return ocpi.GeoLocation{
+		Latitude:  strconv.FormatFloat(o.Latitude+p.Y*0.0001, 'f', 6, 64),
+		Longitude: strconv.FormatFloat(o.Longitude+p.X*0.0001, 'f', 6, 64),
+	}
+}
+
+func (o *OCPI) token(vehicle string) ocpi.CdrToken {
+	return ocpi.CdrToken{
+		CountryCode: o.CountryCode,
+		PartyId:     o.PartyId,
+		UID:         vehicle,
+		Type:        ocpi.TokenAppUser,
+		ContractId:  vehicle,
+	}
+}
+
+// tariff of a charger, from its pricing policy. Time of use periods come
+// first, the last matching winning as in TimeOfUse; surges are noted but
+// not priced.
+func (o *OCPI) tariff(c *Charger, now time.Time) *ocpi.Tariff {
+	base := c.Tariff
+	t := &ocpi.Tariff{
+		CountryCode: o.CountryCode,
+		PartyId:     o.PartyId,
+		Id:          c.Id,
+		Currency:    base.Currency,
+		LastUpdated: now,
+	}
+	policy := c.Pricing
+	if s, ok := policy.(*Surge); ok {
+		t.TariffAltText = append(t.TariffAltText, ocpi.DisplayText{
+			Language: "en",
+			Text:     fmt.Sprintf("Energy price x%.1f while %.0f%% busy or more", s.Multiplier, s.Occupancy*100),
+		})
+		policy = s.Base
+	}
+	if tou, ok := policy.(*TimeOfUse); ok {
+		for i := len(tou.Periods) - 1; i >= 0; i-- {
+			p := tou.Periods[i]
+			t.Elements = append(t.Elements, ocpi.TariffElement{
+				PriceComponents: []ocpi.PriceComponent{
+					{Type: ocpi.ComponentEnergy, Price: majorUnits(p.PerKWh, base.Currency), StepSize: 1},
+				},
+				Restrictions: &ocpi.TariffRestrictions{
+					StartTime: fmt.Sprintf("%02d:00", p.From),
+					EndTime:   fmt.Sprintf("%02d:00", p.To),
+				},
+			})
+		}
+	}
+	components := []ocpi.PriceComponent{
+		{Type: ocpi.ComponentEnergy, Price: majorUnits(base.PerKWh, base.Currency), StepSize: 1},
+	}
+	if base.PerMinute > 0 {
+		components = append(components, ocpi.PriceComponent{
+			Type: ocpi.ComponentTime, Price: majorUnits(base.PerMinute*60, base.Currency), StepSize: 60})
+	}
+	if base.SessionFee > 0 {
+		components = append(components, ocpi.PriceComponent{
+			Type: ocpi.ComponentFlat, Price: majorUnits(base.SessionFee, base.Currency), StepSize: 1})
+	}
+	if base.IdleFee > 0 {
+		components = append(components, ocpi.PriceComponent{
+			Type: ocpi.ComponentParkingTime, Price: majorUnits(base.IdleFee*60, base.Currency), StepSize: 60})
+	}
+	t.Elements = append(t.Elements, ocpi.TariffElement{PriceComponents: components})
+	return t
+}
+
+// onTransaction keeps a CDR of each completed transaction
+func (o *OCPI) onTransaction(t *message.Transaction) {
+	c := FindCharger(o.track, t.Charger)
+	if c == nil {
+		return
+	}
+	conn := o.connector(c, t.End)
+	kWh := float64(t.Units) / 1000
+	hours := t.End.Sub(t.Start).Hours()
+	cdr := &ocpi.CDR{
+		CountryCode:   o.CountryCode,
+		PartyId:       o.PartyId,
+		Id:            t.Id,
+		StartDateTime: t.Start,
+		EndDateTime:   t.End,
+		SessionId:     t.Session,
+		CdrToken:      o.token(t.Vehicle),
+		AuthMethod:    ocpi.AuthCommand,
+		CdrLocation: ocpi.CdrLocation{
+			Id:                 c.Site,
+			Name:               c.Site,
+			Address:            "Track " + c.Site,
+			City:               o.City,
+			Country:            o.Country,
+			Coordinates:        o.coordinates(c),
+			EvseUID:            o.evseUID(c, 0), // stalls aren't told apart once left
+			EvseId:             o.evseId(c, 0),
+			ConnectorId:        conn.Id,
+			ConnectorStandard:  conn.Standard,
+			ConnectorFormat:    conn.Format,
+			ConnectorPowerType: conn.PowerType,
+		},
+		Currency: t.Amount.Currency().Code,
+		ChargingPeriods: []ocpi.ChargingPeriod{{
+			StartDateTime: t.Start,
+			Dimensions: []ocpi.CdrDimension{
+				{Type: ocpi.DimensionEnergy, Volume: kWh},
+				{Type: ocpi.DimensionTime, Volume: hours},
+			},
+		}},
+		TotalCost:   ocpi.Price{ExclVat: t.Amount.AsMajorUnits()},
+		TotalEnergy: kWh,
+		TotalTime:   hours,
+		LastUpdated: t.End,
+	}
+	o.mu.Lock()
+	o.cdrs = append(o.cdrs, cdr)
+	o.mu.Unlock()
+}
+
+// majorUnits of an amount in minor units
+func majorUnits(minor int64, currency string) float64 {
+	return money.New(minor, currency).AsMajorUnits()
+}
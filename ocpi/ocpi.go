@@ -0,0 +1,237 @@
+// Package ocpi holds the OCPI 2.2 objects chargesim serves, those of the
+// Locations, Sessions, CDRs and Tariffs modules it needs.
+package ocpi
+
+import (
+	"time"
+)
+
+// Version served
+const Version = "2.2"
+
+// Status codes in the response envelope
+const (
+	Success          = 1000
+	ClientError      = 2000
+	InvalidParams    = 2001
+	UnknownLocation  = 2003
+	ServerError      = 3000
+	StatusNotHandled = 3001
+)
+
+// Response is the envelope around every reply
+type Response struct {
+	Data          interface{} `json:"data,omitempty"`
+	StatusCode    int         `json:"status_code"`
+	StatusMessage string      `json:"status_message,omitempty"`
+	Timestamp     time.Time   `json:"timestamp"`
+}
+
+type VersionInfo struct {
+	Version string `json:"version"`
+	URL     string `json:"url"`
+}
+
+type Endpoint struct {
+	Identifier string `json:"identifier"`
+	Role       string `json:"role"`
+	URL        string `json:"url"`
+}
+
+type VersionDetails struct {
+	Version   string     `json:"version"`
+	Endpoints []Endpoint `json:"endpoints"`
+}
+
+type GeoLocation struct {
+	Latitude  string `json:"latitude"`
+	Longitude string `json:"longitude"`
+}
+
+type DisplayText struct {
+	Language string `json:"language"`
+	Text     string `json:"text"`
+}
+
+// EVSE statuses
+const (
+	Available   = "AVAILABLE"
+	Blocked     = "BLOCKED"
+	Charging    = "CHARGING"
+	Inoperative = "INOPERATIVE"
+	OutOfOrder  = "OUTOFORDER"
+	Reserved    = "RESERVED"
+	Unknown     = "UNKNOWN"
+)
+
+type Location struct {
+	CountryCode string      `json:"country_code"`
+	PartyId     string      `json:"party_id"`
+	Id          string      `json:"id"`
+	Publish     bool        `json:"publish"`
+	Name        string      `json:"name,omitempty"`
+	Address     string      `json:"address"`
+	City        string      `json:"city"`
+	Country     string      `json:"country"`
+	Coordinates GeoLocation `json:"coordinates"`
+	EVSEs       []*EVSE     `json:"evses,omitempty"`
+	TimeZone    string      `json:"time_zone"`
+	LastUpdated time.Time   `json:"last_updated"`
+}
+
+type EVSE struct {
+	UID         string       `json:"uid"`
+	EvseId      string       `json:"evse_id,omitempty"`
+	Status      string       `json:"status"`
+	Connectors  []*Connector `json:"connectors"`
+	Coordinates *GeoLocation `json:"coordinates,omitempty"`
+	LastUpdated time.Time    `json:"last_updated"`
+}
+
+// Connector standards, formats and power types of the catalog
+const (
+	StandardType2 = "IEC_62196_T2"
+	StandardCCS2  = "IEC_62196_T2_COMBO"
+	FormatSocket  = "SOCKET"
+	FormatCable   = "CABLE"
+	PowerAC3Phase = "AC_3_PHASE"
+	PowerDC       = "DC"
+)
+
+type Connector struct {
+	Id               string    `json:"id"`
+	Standard         string    `json:"standard"`
+	Format           string    `json:"format"`
+	PowerType        string    `json:"power_type"`
+	MaxVoltage       int       `json:"max_voltage"`
+	MaxAmperage      int       `json:"max_amperage"`
+	MaxElectricPower int       `json:"max_electric_power,omitempty"` // W
+	TariffIds        []string  `json:"tariff_ids,omitempty"`
+	LastUpdated      time.Time `json:"last_updated"`
+}
+
+type Price struct {
+	ExclVat float64 `json:"excl_vat"`
+}
+
+// Token types and auth methods
+const (
+	TokenAppUser = "APP_USER"
+	AuthCommand  = "COMMAND"
+)
+
+type CdrToken struct {
+	CountryCode string `json:"country_code"`
+	PartyId     string `json:"party_id"`
+	UID         string `json:"uid"`
+	Type        string `json:"type"`
+	ContractId  string `json:"contract_id"`
+}
+
+// Session statuses
+const (
+	SessionActive    = "ACTIVE"
+	SessionCompleted = "COMPLETED"
+)
+
+type Session struct {
+	CountryCode   string    `json:"country_code"`
+	PartyId       string    `json:"party_id"`
+	Id            string    `json:"id"`
+	StartDateTime time.Time `json:"start_date_time"`
+	KWh           float64   `json:"kwh"`
+	CdrToken      CdrToken  `json:"cdr_token"`
+	AuthMethod    string    `json:"auth_method"`
+	LocationId    string    `json:"location_id"`
+	EvseUID       string    `json:"evse_uid"`
+	ConnectorId   string    `json:"connector_id"`
+	Currency      string    `json:"currency"`
+	TotalCost     *Price    `json:"total_cost,omitempty"`
+	Status        string    `json:"status"`
+	LastUpdated   time.Time `json:"last_updated"`
+}
+
+type CdrLocation struct {
+	Id                 string      `json:"id"`
+	Name               string      `json:"name,omitempty"`
+	Address            string      `json:"address"`
+	City               string      `json:"city"`
+	Country            string      `json:"country"`
+	Coordinates        GeoLocation `json:"coordinates"`
+	EvseUID            string      `json:"evse_uid"`
+	EvseId             string      `json:"evse_id"`
+	ConnectorId        string      `json:"connector_id"`
+	ConnectorStandard  string      `json:"connector_standard"`
+	ConnectorFormat    string      `json:"connector_format"`
+	ConnectorPowerType string      `json:"connector_power_type"`
+}
+
+// Dimensions of a charging period
+const (
+	DimensionEnergy      = "ENERGY"
+	DimensionTime        = "TIME"
+	DimensionParkingTime = "PARKING_TIME"
+)
+
+type CdrDimension struct {
+	Type   string  `json:"type"`
+	Volume float64 `json:"volume"`
+}
+
+type ChargingPeriod struct {
+	StartDateTime time.Time      `json:"start_date_time"`
+	Dimensions    []CdrDimension `json:"dimensions"`
+}
+
+type CDR struct {
+	CountryCode      string           `json:"country_code"`
+	PartyId          string           `json:"party_id"`
+	Id               string           `json:"id"`
+	StartDateTime    time.Time        `json:"start_date_time"`
+	EndDateTime      time.Time        `json:"end_date_time"`
+	SessionId        string           `json:"session_id,omitempty"`
+	CdrToken         CdrToken         `json:"cdr_token"`
+	AuthMethod       string           `json:"auth_method"`
+	CdrLocation      CdrLocation      `json:"cdr_location"`
+	Currency         string           `json:"currency"`
+	ChargingPeriods  []ChargingPeriod `json:"charging_periods"`
+	TotalCost        Price            `json:"total_cost"`
+	TotalEnergy      float64          `json:"total_energy"`
+	TotalTime        float64          `json:"total_time"` // hours
+	TotalParkingTime float64          `json:"total_parking_time,omitempty"`
+	LastUpdated      time.Time        `json:"last_updated"`
+}
+
+// Price component types
+const (
+	ComponentEnergy      = "ENERGY"
+	ComponentFlat        = "FLAT"
+	ComponentParkingTime = "PARKING_TIME"
+	ComponentTime        = "TIME"
+)
+
+type PriceComponent struct {
+	Type     string  `json:"type"`
+	Price    float64 `json:"price"`
+	StepSize int     `json:"step_size"`
+}
+
+type TariffRestrictions struct {
+	StartTime string `json:"start_time,omitempty"`
+	EndTime   string `json:"end_time,omitempty"`
+}
+
+type TariffElement struct {
+	PriceComponents []PriceComponent    `json:"price_components"`
+	Restrictions    *TariffRestrictions `json:"restrictions,omitempty"`
+}
+
+type Tariff struct {
+	CountryCode   string          `json:"country_code"`
+	PartyId       string          `json:"party_id"`
+	Id            string          `json:"id"`
+	Currency      string          `json:"currency"`
+	TariffAltText []DisplayText   `json:"tariff_alt_text,omitempty"`
+	Elements      []TariffElement `json:"elements"`
+	LastUpdated   time.Time       `json:"last_updated"`
+}
@@ -0,0 +1,138 @@
+package ocpp
+
+import (
+	"time"
+)
+
+type BootNotificationReq struct {
+	ChargePointVendor       string `json:"chargePointVendor"`
+	ChargePointModel        string `json:"chargePointModel"`
+	ChargePointSerialNumber string `json:"chargePointSerialNumber,omitempty"`
+	FirmwareVersion         string `json:"firmwareVersion,omitempty"`
+}
+
+type BootNotificationConf struct {
+	Status      string    `json:"status"`
+	CurrentTime time.Time `json:"currentTime"`
+	Interval    int       `json:"interval"` // seconds between heartbeats
+}
+
+type HeartbeatReq struct{}
+
+type HeartbeatConf struct {
+	CurrentTime time.Time `json:"currentTime"`
+}
+
+type StatusNotificationReq struct {
+	ConnectorId int        `json:"connectorId"`
+	ErrorCode   string     `json:"errorCode"`
+	Status      string     `json:"status"`
+	Info        string     `json:"info,omitempty"`
+	Timestamp   *time.Time `json:"timestamp,omitempty"`
+}
+
+type StatusNotificationConf struct{}
+
+type IdTagInfo struct {
+	Status string `json:"status"`
+}
+
+type AuthorizeReq struct {
+	IdTag string `json:"idTag"`
+}
+
+type AuthorizeConf struct {
+	IdTagInfo IdTagInfo `json:"idTagInfo"`
+}
+
+type StartTransactionReq struct {
+	ConnectorId   int       `json:"connectorId"`
+	IdTag         string    `json:"idTag"`
+	MeterStart    int       `json:"meterStart"` // Wh
+	ReservationId *int      `json:"reservationId,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+type StartTransactionConf struct {
+	IdTagInfo     IdTagInfo `json:"idTagInfo"`
+	TransactionId int       `json:"transactionId"`
+}
+
+type StopTransactionReq struct {
+	IdTag         string    `json:"idTag,omitempty"`
+	MeterStop     int       `json:"meterStop"` // Wh
+	Timestamp     time.Time `json:"timestamp"`
+	TransactionId int       `json:"transactionId"`
+	Reason        string    `json:"reason,omitempty"`
+}
+
+type StopTransactionConf struct {
+	IdTagInfo *IdTagInfo `json:"idTagInfo,omitempty"`
+}
+
+// Reasons for stopping a transaction
+const (
+	ReasonLocal          = "Local"
+	ReasonRemote         = "Remote"
+	ReasonEVDisconnected = "EVDisconnected"
+	ReasonPowerLoss      = "PowerLoss"
+	ReasonOther          = "Other"
+	ReasonDeAuthorized   = "DeAuthorized"
+	ReasonUnlockCommand  = "UnlockCommand"
+	ReasonEmergencyStop  = "EmergencyStop"
+	ReasonHardReset      = "HardReset"
+	ReasonSoftReset      = "SoftReset"
+	ReasonReboot         = "Reboot"
+)
+
+// Measurands sampled
+const (
+	EnergyActiveImportRegister = "Energy.Active.Import.Register"
+	PowerActiveImport          = "Power.Active.Import"
+	SoC                        = "SoC"
+)
+
+type SampledValue struct {
+	Value     string `json:"value"`
+	Context   string `json:"context,omitempty"`
+	Measurand string `json:"measurand,omitempty"`
+	Unit      string `json:"unit,omitempty"`
+}
+
+type MeterValue struct {
+	Timestamp    time.Time      `json:"timestamp"`
+	SampledValue []SampledValue `json:"sampledValue"`
+}
+
+type MeterValuesReq struct {
+	ConnectorId   int          `json:"connectorId"`
+	TransactionId *int         `json:"transactionId,omitempty"`
+	MeterValue    []MeterValue `json:"meterValue"`
+}
+
+type MeterValuesConf struct{}
+
+type RemoteStartTransactionReq struct {
+	ConnectorId *int   `json:"connectorId,omitempty"`
+	IdTag       string `json:"idTag"`
+}
+
+type RemoteStopTransactionReq struct {
+	TransactionId int `json:"transactionId"`
+}
+
+// Availability types
+const (
+	Operative   = "Operative"
+	Inoperative = "Inoperative"
+)
+
+type ChangeAvailabilityReq struct {
+	ConnectorId int    `json:"connectorId"`
+	Type        string `json:"type"`
+}
+
+// StatusConf answers the remote commands, and ChangeAvailability
+type StatusConf struct {
+	Status string `json:"status"`
+}
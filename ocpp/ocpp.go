@@ -0,0 +1,156 @@
+// Package ocpp holds the OCPP 1.6J messages chargesim speaks, as a charge
+// point to a central system and as a central system to charge points.
+package ocpp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Subprotocol negotiated on the websocket
+const Subprotocol = "ocpp1.6"
+
+// Message types, the first element of every frame
+const (
+	Call       = 2
+	CallResult = 3
+	CallError  = 4
+)
+
+// Actions
+const (
+	BootNotification       = "BootNotification"
+	Heartbeat              = "Heartbeat"
+	StatusNotification     = "StatusNotification"
+	StartTransaction       = "StartTransaction"
+	StopTransaction        = "StopTransaction"
+	MeterValues            = "MeterValues"
+	Authorize              = "Authorize"
+	RemoteStartTransaction = "RemoteStartTransaction"
+	RemoteStopTransaction  = "RemoteStopTransaction"
+	ChangeAvailability     = "ChangeAvailability"
+)
+
+// Statuses in replies
+const (
+	Accepted  = "Accepted"
+	Rejected  = "Rejected"
+	Pending   = "Pending"
+	Scheduled = "Scheduled"
+	Invalid   = "Invalid"
+)
+
+// Connector statuses
+const (
+	Available     = "Available"
+	Preparing     = "Preparing"
+	Charging      = "Charging"
+	SuspendedEV   = "SuspendedEV"
+	SuspendedEVSE = "SuspendedEVSE"
+	Finishing     = "Finishing"
+	Reserved      = "Reserved"
+	Unavailable   = "Unavailable"
+	Faulted       = "Faulted"
+)
+
+// Connector error codes, of those the simulation can have
+const (
+	NoError       = "NoError"
+	InternalError = "InternalError"
+	ReaderFailure = "ReaderFailure"
+	OtherError    = "OtherError"
+)
+
+// CallError codes
+const (
+	NotImplemented     = "NotImplemented"
+	NotSupported       = "NotSupported"
+	FormationViolation = "FormationViolation"
+	GenericError       = "GenericError"
+)
+
+// Frame is an OCPP-J message: a Call, its CallResult or a CallError
+type Frame struct {
+	Type             int
+	Id               string
+	Action           string          // Call
+	Payload          json.RawMessage // Call and CallResult
+	ErrorCode        string          // CallError
+	ErrorDescription string          // CallError
+}
+
+// NewCall makes a Call of action with a fresh message id
+func NewCall(action string, payload interface{}) (*Frame, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Frame{
+		Type:    Call,
+		Id:      uuid.Must(uuid.NewV4()).String(),
+		Action:  action,
+		Payload: data,
+	}, nil
+}
+
+// NewResult answers the Call with id
+func NewResult(id string, payload interface{}) (*Frame, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Frame{Type: CallResult, Id: id, Payload: data}, nil
+}
+
+// NewError refuses the Call with id
+func NewError(id, code, description string) *Frame {
+	return &Frame{Type: CallError, Id: id, ErrorCode: code, ErrorDescription: description}
+}
+
+func (f *Frame) MarshalJSON() ([]byte, error) {
+	switch f.Type {
+	case Call:
+		return json.Marshal([]interface{}{f.Type, f.Id, f.Action, f.Payload})
+	case CallResult:
+		return json.Marshal([]interface{}{f.Type, f.Id, f.Payload})
+	case CallError:
+		return json.Marshal([]interface{}{f.Type, f.Id, f.ErrorCode, f.ErrorDescription, struct{}{}})
+	}
+	return nil, fmt.Errorf("ocpp: unknown message type %d", f.Type)
+}
+
+func (f *Frame) UnmarshalJSON(data []byte) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if len(fields) < 3 {
+		return errors.New("ocpp: short frame")
+	}
+	if err := json.Unmarshal(fields[0], &f.Type); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(fields[1], &f.Id); err != nil {
+		return err
+	}
+	switch f.Type {
+	case Call:
+		if len(fields) < 4 {
+			return errors.New("ocpp: short call")
+		}
+		f.Payload = fields[3]
+		return json.Unmarshal(fields[2], &f.Action)
+	case CallResult:
+		f.Payload = fields[2]
+		return nil
+	case CallError:
+		if len(fields) > 3 {
+			json.Unmarshal(fields[3], &f.ErrorDescription)
+		}
+		return json.Unmarshal(fields[2], &f.ErrorCode)
+	}
+	return fmt.Errorf("ocpp: unknown message type %d", f.Type)
+}
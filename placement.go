@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+// Placement of a budget of chargers, in radians round the track, one for
+// each in the order of the scenario's Chargers.
+type Placement []float64
+
+// Weights turn an Outcome into a cost to minimise
+type Weights struct {
+	Flat float64 // per vehicle left flat
+	Wait float64 // per minute of average wait
+	Idle float64 // per percent of stall time unused
+}
+
+// A flat is an hour of everyone's wait, and an idle charger matters a
+// little: it's money spent for nothing.
+func DefaultWeights() Weights {
+	return Weights{Flat: 60, Wait: 1, Idle: 0.1}
+}
+
+func (w Weights) Cost(o *Outcome) float64 {
+	return w.Flat*float64(o.Flats) + w.Wait*o.Wait + w.Idle*(100-o.Utilization)
+}
+
+// Siting is deciding where to build a scenario's chargers: each placement
+// tried is run headless over every seed and scored by its average cost.
+type Siting struct {
+	Scenario *Scenario
+	Seeds    []int64
+	Weights  Weights
+	Rand     *rand.Rand
+	Runs     int
+
+	lowest float64
+}
+
+func NewSiting(s *Scenario, seeds int) *Siting {
+	siting := &Siting{
+		Scenario: s,
+		Weights:  DefaultWeights(),
+		Rand:     rand.New(rand.NewSource(s.Seed)),
+		lowest:   math.Inf(1),
+	}
+	for i := 0; i < seeds; i++ {
+		siting.Seeds = append(siting.Seeds, s.Seed+int64(i))
+	}
+	return siting
+}
+
+// Evaluate the placement, a nil one laying the chargers out at random:
+// its cost averaged over the seeds, and the outcome of each.
+func (s *Siting) Evaluate(p Placement) (float64, []*Outcome) {
+	cost := 0.0
+	outcomes := []*Outcome{}
+	for _, seed := range s.Seeds {
+		scenario := *s.Scenario
+		scenario.Seed = seed
+		o := scenario.Run(p)
+		cost += s.Weights.Cost(o)
+		outcomes = append(outcomes, o)
+		s.Runs++
+	}
+	cost = cost / float64(len(s.Seeds))
+	if len(p) == len(s.Scenario.Chargers) && cost < s.lowest {
+		s.lowest = cost
+		log.Printf("siting: run %d, cost %.1f at %s", s.Runs, cost, p)
+	}
+	return cost, outcomes
+}
+
+// random placement of every charger
+func (s *Siting) random() Placement {
+	p := make(Placement, len(s.Scenario.Chargers))
+	for i := range p {
+		p[i] = s.Rand.Float64() * 2 * math.Pi
+	}
+	return p
+}
+
+// Optimizer searches for the placement of lowest cost
+type Optimizer interface {
+	Optimize(s *Siting) Placement
+}
+
+// NewOptimizer by name: greedy, anneal or genetic. Defaults to greedy.
+func NewOptimizer(name string) Optimizer {
+	switch name {
+	case "anneal":
+		return Anneal{Steps: 60, Temperature: 10, Cooling: 0.95}
+	case "genetic":
+		return Genetic{Population: 10, Generations: 6, Mutation: 0.2}
+	}
+	return Greedy{Candidates: 24}
+}
+
+// Greedy builds one charger at a time, each at whichever of the candidate
+// spots evenly round the track does best with those already built.
+type Greedy struct {
+	Candidates int
+}
+
+func (g Greedy) Optimize(s *Siting) Placement {
+	p := Placement{}
+	for range s.Scenario.Chargers {
+		best, lowest := 0.0, math.Inf(1)
+		for i := 0; i < g.Candidates; i++ {
+			theta := float64(i) / float64(g.Candidates) * 2 * math.Pi
+			if cost, _ := s.Evaluate(append(p[:len(p):len(p)], theta)); cost < lowest {
+				best, lowest = theta, cost
+			}
+		}
+		p = append(p, best)
+	}
+	return p
+}
+
+// Anneal moves a charger at a time from a random start, taking worse
+// placements less often and moving less far as it cools.
+type Anneal struct {
+	Steps       int
+	Temperature float64 // in units of cost
+	Cooling     float64 // each step
+}
+
+func (a Anneal) Optimize(s *Siting) Placement {
+	p := s.random()
+	cost, _ := s.Evaluate(p)
+	best, lowest := p, cost
+	temp := a.Temperature
+	for i := 0; i < a.Steps; i++ {
+		next := append(Placement(nil), p...)
+		k := s.Rand.Intn(len(next))
+		next[k] += s.Rand.NormFloat64() * math.Pi / 4 * temp / a.Temperature
+		c, _ := s.Evaluate(next)
+		if c < cost || s.Rand.Float64() < math.Exp((cost-c)/temp) {
+			p, cost = next, c
+		}
+		if cost < lowest {
+			best, lowest = p, cost
+		}
+		temp = temp * a.Cooling
+	}
+	return best
+}
+
+// Genetic breeds a population of placements, the fitter of two picked at
+// random for each parent, every charger from one parent or the other and
+// now and then moved. The best two carry over to the next generation.
+type Genetic struct {
+	Population  int
+	Generations int
+	Mutation    float64 // chance a charger moves
+}
+
+type candidate struct {
+	p    Placement
+	cost float64
+}
+
+func (g Genetic) Optimize(s *Siting) Placement {
+	population := []candidate{}
+	for i := 0; i < g.Population; i++ {
+		p := s.random()
+		cost, _ := s.Evaluate(p)
+		population = append(population, candidate{p, cost})
+	}
+	byCost := func() {
+		sort.Slice(population, func(i, j int) bool {
+			return population[i].cost < population[j].cost
+		})
+	}
+	pick := func() candidate {
+		a := population[s.Rand.Intn(len(population))]
+		b := population[s.Rand.Intn(len(population))]
+		if b.cost < a.cost {
+			return b
+		}
+		return a
+	}
+
+	for gen := 0; gen < g.Generations; gen++ {
+		byCost()
+		next := append([]candidate(nil), population[:2]...)
+		for len(next) < g.Population {
+			a, b := pick(), pick()
+			child := make(Placement, len(a.p))
+			for k := range child {
+				child[k] = a.p[k]
+				if s.Rand.Float64() < 0.5 {
+					child[k] = b.p[k]
+				}
+				if s.Rand.Float64() < g.Mutation {
+					child[k] += s.Rand.NormFloat64() * math.Pi / 8
+				}
+			}
+			cost, _ := s.Evaluate(child)
+			next = append(next, candidate{child, cost})
+		}
+		population = next
+	}
+	byCost()
+	return population[0].p
+}
+
+// degrees round the track, from 0 to 360
+func degrees(theta float64) float64 {
+	d := math.Mod(theta*180/math.Pi, 360)
+	if d < 0 {
+		d += 360
+	}
+	return d
+}
+
+func (p Placement) String() string {
+	s := ""
+	for i, theta := range p {
+		if i > 0 {
+			s += ", "
+		}
+		s += fmt.Sprintf("%.0f°", degrees(theta))
+	}
+	return s
+}
+
+// Report writes the placement as CSV: each charger, its model, and where
+// round the track in degrees.
+func (s *Siting) Report(w io.Writer, p Placement) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"charger", "model", "degrees"})
+	for i, theta := range p {
+		out.Write([]string{
+			fmt.Sprintf("C%02d", i+1),
+			s.Scenario.Chargers[i],
+			fmt.Sprintf("%.1f", degrees(theta)),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// optimizePlacement searches for where to build the scenario's chargers
+// with the named optimizer, and prints the placement found against the
+// random layout.
+func optimizePlacement(scenario *Scenario, method string, seeds int) {
+	s := NewSiting(scenario, seeds)
+	random, _ := s.Evaluate(nil)
+	log.Printf("siting: random layout, cost %.1f", random)
+
+	p := NewOptimizer(method).Optimize(s)
+	cost, outcomes := s.Evaluate(p)
+	if err := s.Report(os.Stdout, p); err != nil {
+		log.Println(err)
+	}
+	fmt.Printf("%s placement after %d runs, cost %.1f against %.1f laid out at random\n", method, s.Runs, cost, random)
+	for i, o := range outcomes {
+		fmt.Printf("  seed %d: %s\n", s.Seeds[i], o)
+	}
+}
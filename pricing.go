@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// PricingPolicy decides the tariff a charger offers at a given time
+type PricingPolicy interface {
+	Tariff(c *Charger, now time.Time) *Tariff
+}
+
+// FlatPricing always offers the charger's own tariff
+type FlatPricing struct{}
+
+func (p FlatPricing) Tariff(c *Charger, now time.Time) *Tariff {
+	return c.Tariff
+}
+
+// PricePeriod is a price per kWh from hour From until hour To, wrapping
+// past midnight when To is before From.
+type PricePeriod struct {
+	From, To int
+	PerKWh   int64
+}
+
+func (p PricePeriod) Covers(hour int) bool {
+	if p.From <= p.To {
+		return hour >= p.From && hour < p.To
+	}
+	return hour >= p.From || hour < p.To
+}
+
+// TimeOfUse replaces the energy price of the charger's tariff during each
+// period, the last matching period winning.
+type TimeOfUse struct {
+	Periods []PricePeriod
+}
+
+func (p *TimeOfUse) Tariff(c *Charger, now time.Time) *Tariff {
+	t := *c.Tariff
+	for _, period := range p.Periods {
+		if period.Covers(now.Hour()) {
+			t.PerKWh = period.PerKWh
+		}
+	}
+	return &t
+}
+
+// Surge raises the energy price of Base once the charger is busy
+type Surge struct {
+	Base       PricingPolicy
+	Occupancy  float64 // surge at or above this share of the queue in use
+	Multiplier float64
+}
+
+func (p *Surge) Tariff(c *Charger, now time.Time) *Tariff {
+	base := p.Base.Tariff(c, now)
+	if c.Occupancy() < p.Occupancy {
+		return base
+	}
+	t := *base
+	t.PerKWh = int64(math.Round(float64(t.PerKWh) * p.Multiplier))
+	return &t
+}
+
+// NewPricing builds one of the built in policies by name: flat, tou
+// (evening peak) or surge (time of use with surge when busy).
+func NewPricing(name string) PricingPolicy {
+	tou := &TimeOfUse{
+		Periods: []PricePeriod{
+			{From: 22, To: 7, PerKWh: 30},
+			{From: 17, To: 21, PerKWh: 65},
+		},
+	}
+	switch name {
+	case "tou":
+		return tou
+	case "surge":
+		return &Surge{Base: tou, Occupancy: 0.66, Multiplier: 1.5}
+	}
+	return FlatPricing{}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"time"
+)
+
+// Profile scales a quantity by hour of day and day of week. A factor of
+// 1.0 is a typical hour, values between the hours are interpolated.
+type Profile struct {
+	Hourly  [24]float64
+	Weekday [7]float64 // time.Weekday order, Sunday first
+}
+
+func (p *Profile) Factor(t time.Time) float64 {
+	h := t.Hour()
+	frac := float64(t.Minute())/60 + float64(t.Second())/3600
+	hourly := p.Hourly[h]*(1-frac) + p.Hourly[(h+1)%24]*frac
+	return hourly * p.Weekday[t.Weekday()]
+}
+
+// Demand groups the profiles that modulate driver behaviour over the day.
+type Demand struct {
+	// Spawn scales new vehicles joining the road and drivers leaving home
+	Spawn *Profile
+	// Park scales drivers heading home for the day
+	Park *Profile
+	// HomeCharging is the share of drivers plugged in at home, which also
+	// decides whether a driver sets out on a full battery.
+	HomeCharging *Profile
+	// Stop scales drivers parking at a destination: work, shops, errands
+	Stop *Profile
+
+	DepartRate     float64       // departures from home per vehicle per hour
+	ParkRate       float64       // returns home per vehicle per hour
+	HomeChargeRate float64       // charge gained per hour while plugged in at home
+	StopRate       float64       // destination stops per vehicle per hour
+	Dwell          time.Duration // average time parked at a destination
+	PlugBelow      float64       // drivers plug in at a destination below this charge
+	PriceSensitive float64       // share of drivers choosing chargers on price
+	Booking        float64       // share of drivers reserving a stall ahead
+}
+
+func NewDemand() *Demand {
+	return &Demand{
+		// commuter peaks around 8am and 5pm, quiet weekends
+		Spawn: &Profile{
+			Hourly: [24]float64{
+				0.2, 0.1, 0.1, 0.1, 0.2, 0.5, 1.2, 2.0, 2.2, 1.5, 1.0, 1.0,
+				1.1, 1.0, 1.0, 1.2, 1.6, 2.0, 1.8, 1.2, 0.8, 0.6, 0.4, 0.3,
+			},
+			Weekday: [7]float64{0.7, 1.0, 1.0, 1.0, 1.0, 1.1, 0.8},
+		},
+		// home in the evening, rarely during the morning rush
+		Park: &Profile{
+			Hourly: [24]float64{
+				1.0, 1.0, 1.0, 1.0, 0.5, 0.3, 0.2, 0.1, 0.1, 0.2, 0.4, 0.5,
+				0.6, 0.5, 0.5, 0.6, 1.0, 1.5, 2.0, 2.5, 2.5, 2.0, 1.5, 1.2,
+			},
+			Weekday: [7]float64{1.0, 1.0, 1.0, 1.0, 1.0, 1.0, 1.0},
+		},
+		// overnight charging, mostly unplugged once the day starts
+		HomeCharging: &Profile{
+			Hourly: [24]float64{
+				0.9, 0.9, 0.9, 0.9, 0.9, 0.9, 0.8, 0.7, 0.4, 0.2, 0.2, 0.2,
+				0.2, 0.2, 0.2, 0.2, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9,
+			},
+			Weekday: [7]float64{1.0, 1.0, 1.0, 1.0, 1.0, 1.0, 1.0},
+		},
+		// working hours and lunchtime shopping, busier at weekends
+		Stop: &Profile{
+			Hourly: [24]float64{
+				0.0, 0.0, 0.0, 0.0, 0.0, 0.1, 0.3, 0.8, 1.8, 1.6, 1.4, 1.6,
+				2.0, 1.6, 1.4, 1.2, 1.0, 0.8, 0.8, 0.6, 0.4, 0.2, 0.1, 0.0,
+			},
+			Weekday: [7]float64{1.2, 1.0, 1.0, 1.0, 1.0, 1.0, 1.3},
+		},
+		DepartRate:     0.5,
+		ParkRate:       0.1,
+		HomeChargeRate: 10.0,
+		StopRate:       0.2,
+		Dwell:          2 * time.Hour,
+		PlugBelow:      80.0,
+		PriceSensitive: 0.3,
+		Booking:        0.2,
+	}
+}
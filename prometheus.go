@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// promWriter writes metrics in the Prometheus text exposition format
+type promWriter struct {
+	w io.Writer
+}
+
+// metric starts a metric family, its help and type: counter or gauge
+func (p promWriter) metric(name, kind, help string) {
+	fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample of the metric, with label pairs of name then value
+func (p promWriter) sample(name string, value float64, labels ...string) {
+	if len(labels) > 0 {
+		pairs := []string{}
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], promEscape(labels[i+1])))
+		}
+		name = name + "{" + strings.Join(pairs, ",") + "}"
+	}
+	fmt.Fprintf(p.w, "%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// promEscape drops control characters but newline, which %q would write
+// as escapes Prometheus doesn't know.
+func promEscape(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' && r != '\n' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// vehicle statuses always reported, zero or not
+var promStatuses = []string{"drive", "parked", "queued", "charging", "flat"}
+
+// prometheusAPI serves the simulation's metrics for Prometheus to scrape:
+// vehicles by status, each charger's queue, energy and turned away, and the
+// tick time, with the websocket clients and the broadcasts dropped.
+func prometheusAPI(world *World, hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var out bytes.Buffer
+		p := promWriter{&out}
+		done := make(chan struct{})
+		world.Do(func() {
+			defer close(done)
+			m := world.Metrics
+
+			p.metric("chargesim_ticks_total", "counter", "Simulation ticks run.")
+			p.sample("chargesim_ticks_total", float64(m.Ticks))
+			p.metric("chargesim_simulated_seconds_total", "counter", "Simulated time run.")
+			p.sample("chargesim_simulated_seconds_total", m.Hours*3600)
+			p.metric("chargesim_tick_duration_seconds", "gauge", "Time taken running the last tick.")
+			p.sample("chargesim_tick_duration_seconds", m.TickTime.Seconds())
+			p.metric("chargesim_tick_seconds_total", "counter", "Time taken running every tick.")
+			p.sample("chargesim_tick_seconds_total", m.Running.Seconds())
+
+			statuses := m.Statuses()
+			known := make(map[string]bool)
+			for _, status := range promStatuses {
+				known[status] = true
+			}
+			others := []string{}
+			for status := range statuses {
+				if !known[status] {
+					others = append(others, status)
+				}
+			}
+			sort.Strings(others)
+			p.metric("chargesim_vehicles", "gauge", "Vehicles on the track by status.")
+			for _, status := range append(promStatuses, others...) {
+				p.sample("chargesim_vehicles", float64(statuses[status]), "status", status)
+			}
+			p.metric("chargesim_vehicle_flats_total", "counter", "Times vehicles have gone flat.")
+			p.sample("chargesim_vehicle_flats_total", float64(m.Summary().Flats))
+
+			p.metric("chargesim_charger_queue_length", "gauge", "Vehicles waiting at a charger, not plugged in.")
+			for _, c := range m.Chargers {
+				p.sample("chargesim_charger_queue_length", float64(c.Queue), "charger", c.Name)
+			}
+			p.metric("chargesim_charger_energy_kwh_total", "counter", "Energy a charger has delivered.")
+			for _, c := range m.Chargers {
+				p.sample("chargesim_charger_energy_kwh_total", c.KWh, "charger", c.Name)
+			}
+			p.metric("chargesim_charger_rejected_total", "counter", "Vehicles a charger's full queue has turned away.")
+			for _, c := range m.Chargers {
+				p.sample("chargesim_charger_rejected_total", float64(c.Rejected), "charger", c.Name)
+			}
+			p.metric("chargesim_charger_occupancy_ratio", "gauge", "Share of a charger's stall time plugged in, over the run.")
+			for _, c := range m.Chargers {
+				p.sample("chargesim_charger_occupancy_ratio", c.Occupancy/100, "charger", c.Name)
+			}
+		})
+		<-done
+
+		p.metric("chargesim_websocket_clients", "gauge", "Websocket clients connected.")
+		p.sample("chargesim_websocket_clients", float64(hub.Connected()))
+		p.metric("chargesim_broadcast_dropped_total", "counter", "Broadcasts dropped for clients that fell behind.")
+		p.sample("chargesim_broadcast_dropped_total", float64(hub.Dropped()))
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if _, err := out.WriteTo(w); err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+// Kinds of charger fault
+const (
+	FaultOutage  = "outage"  // no charging at all
+	FaultDerated = "derated" // charging at reduced power
+	FaultPayment = "payment" // cannot start new sessions
+)
+
+// share of power left on a derated charger
+const DeratedShare = 0.5
+
+// Fail the charger with a random fault, repaired after a draw from MTTR
+// of work on site.
+func (c *Charger) Fail() {
+	r := c.world.Rand.Float64()
+	switch {
+	case r < 0.5:
+		c.SetFault(FaultOutage)
+	case r < 0.8:
+		c.SetFault(FaultDerated)
+	default:
+		c.SetFault(FaultPayment)
+	}
+	c.repairLeft = c.world.Dwell(c.MTTR)
+}
+
+// SetFault puts the charger in fault, sending on its way anyone it can no
+// longer serve.
+func (c *Charger) SetFault(fault string) {
+	c.Fault = fault
+	c.Status = c.Fault
+	c.world.Event(Event{Kind: FaultEvent, Charger: c.Name, Reason: c.Fault})
+
+	switch c.Fault {
+	case FaultOutage:
+		c.Eject(c.queue)
+	case FaultPayment:
+		// those waiting cannot pay, the vehicle charging carries on
+		c.EjectWaiting()
+	}
+}
+
+// EjectWaiting sends away the vehicles queued, the one charging carrying on
+func (c *Charger) EjectWaiting() {
+	if len(c.queue) > 0 && c.sessions[c.queue[0]] != nil {
+		c.Eject(c.queue[1:])
+	} else {
+		c.Eject(c.queue)
+	}
+}
+
+// Eject vehicles from the queue, closing their sessions
+func (c *Charger) Eject(vehicles []*Vehicle) {
+	for _, v := range append([]*Vehicle{}, vehicles...) {
+		c.world.Event(Event{Kind: EjectEvent, Vehicle: v.Name, Charger: c.Name, Charge: v.Charge})
+		if c.AC {
+			c.Unplug(v)
+			continue
+		}
+		c.remove(v)
+		c.EndSession(v)
+		delete(c.reserved, v)
+		v.Drive()
+	}
+}
+
+// Online when in service and free of faults, or only derated
+func (c *Charger) Online() bool {
+	return !c.Unavailable && (c.Fault == "" || c.Fault == FaultDerated)
+}
+
+// Reliability fails a working charger at random, once every MTBF on
+// average, and works off the repair of a faulted one. Repairs wait for a
+// maintenance crew on site when the World has crews. Remote chargers
+// report their own faults.
+func (c *Charger) Reliability() {
+	if !c.world.Faults || c.Remote {
+		return
+	}
+	if c.Fault == "" {
+		if c.MTBF > 0 && c.world.Chance(1/c.MTBF.Hours()) {
+			c.Fail()
+		}
+		return
+	}
+	if len(c.world.crews) > 0 && (c.crew == nil || c.crew.Status != "repair") {
+		return
+	}
+	c.repairLeft -= c.world.Clock.Step
+	if c.repairLeft > 0 {
+		return
+	}
+	c.Repair()
+}
+
+// Repair the fault, freeing any crew on it
+func (c *Charger) Repair() {
+	c.world.Event(Event{Kind: RepairEvent, Charger: c.Name})
+	c.Fault = ""
+	c.Status = "online"
+	if c.Unavailable {
+		c.Status = "unavailable"
+	}
+	if c.crew != nil {
+		c.crew.Done()
+		c.crew = nil
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rooprob/chargesim/message"
+)
+
+// Recorder writes every tick's render, the messages broadcast to clients,
+// to a gzipped file: a line a tick, the JSON array of its messages.
+type Recorder struct {
+	Frames int // written so far
+
+	mu    sync.Mutex
+	file  *os.File
+	gz    *gzip.Writer
+	frame [][]byte
+}
+
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: f, gz: gzip.NewWriter(f)}, nil
+}
+
+// Frame writes the tick rendered so far, starting the next
+func (r *Recorder) Frame() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.gz == nil || len(r.frame) == 0 {
+		return
+	}
+	line := append([]byte("["), bytes.Join(r.frame, []byte(","))...)
+	if _, err := r.gz.Write(append(line, "]\n"...)); err != nil {
+		log.Println(err)
+	}
+	r.frame = r.frame[:0]
+	r.Frames++
+}
+
+// Add a message to the tick being rendered
+func (r *Recorder) Add(data []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.frame = append(r.frame, data)
+	r.mu.Unlock()
+}
+
+// Close the recording. The tick being rendered is left out, it may not
+// be all there.
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.gz == nil {
+		return nil
+	}
+	err := r.gz.Close()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	r.gz = nil
+	return err
+}
+
+// Frame of a recording, a tick's messages and its simulated time
+type Frame struct {
+	Time     string
+	Messages []json.RawMessage
+}
+
+// LoadRecording reads every frame of a recording
+func LoadRecording(path string) ([]Frame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	in := bufio.NewReader(gz)
+
+	frames := []Frame{}
+	for {
+		line, err := in.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			// never closed, keep the frames written out
+			log.Printf("%s: cut short after %d frames", path, len(frames))
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		var frame Frame
+		if err := json.Unmarshal(line, &frame.Messages); err != nil {
+			return nil, fmt.Errorf("%s frame %d: %v", path, len(frames), err)
+		}
+		// the track comes first, telling the time
+		if len(frame.Messages) > 0 {
+			var track struct {
+				Time string `json:"time"`
+			}
+			json.Unmarshal(frame.Messages[0], &track)
+			frame.Time = track.Time
+		}
+		frames = append(frames, frame)
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("%s: nothing recorded", path)
+	}
+	return frames, nil
+}
+
+// Player replays a recording to the hub's clients, a frame each Interval
+// at Speed 1. Paused, it repeats the frame for clients joining.
+type Player struct {
+	Frames []Frame
+	Speed  float64
+
+	frame    int
+	playing  bool
+	hub      *Hub
+	requests chan func()
+}
+
+func NewPlayer(frames []Frame, hub *Hub) *Player {
+	return &Player{
+		Frames:   frames,
+		Speed:    1,
+		playing:  true,
+		hub:      hub,
+		requests: make(chan func(), 16),
+	}
+}
+
+// Do runs f between frames
+func (p *Player) Do(f func()) {
+	p.requests <- f
+}
+
+func (p *Player) Run() {
+	for {
+		select {
+		case f := <-p.requests:
+			f()
+		case <-time.After(time.Duration(float64(Interval) / p.Speed)):
+			if p.playing {
+				p.next()
+			}
+			p.show()
+		}
+	}
+}
+
+// next frame, pausing at the end
+func (p *Player) next() {
+	if p.frame < len(p.Frames)-1 {
+		p.frame++
+	} else {
+		p.playing = false
+	}
+}
+
+// show the frame to every client, and where the replay is up to
+func (p *Player) show() {
+	for _, data := range p.Frames[p.frame].Messages {
+		p.hub.broadcastData(data)
+	}
+	p.hub.broadcastAll(p.state())
+}
+
+func (p *Player) state() *message.Replay {
+	return message.NewReplay(p.frame, len(p.Frames), p.Speed, p.playing, p.Frames[p.frame].Time)
+}
+
+// Control the replay as a client asks, then show it to everyone
+func (p *Player) Control(req *message.Replay) error {
+	switch req.Action {
+	case "play":
+		if p.frame == len(p.Frames)-1 {
+			p.frame = 0
+		}
+		p.playing = true
+	case "pause":
+		p.playing = false
+	case "seek":
+		if req.Frame < 0 || req.Frame >= len(p.Frames) {
+			return fmt.Errorf("no frame %d of %d", req.Frame, len(p.Frames))
+		}
+		p.frame = req.Frame
+	case "speed":
+		if req.Speed <= 0 {
+			return fmt.Errorf("speed must be above zero")
+		}
+		p.Speed = req.Speed
+	default:
+		return fmt.Errorf("unknown action %q", req.Action)
+	}
+	p.show()
+	return nil
+}
+
+// handleReplay lets websocket users control the replay, replying only to
+// refuse a control
+func handleReplay(hub *Hub, p *Player) {
+	hub.handle(message.KindReplay, func(data []byte, client *Client) {
+		var req message.Replay
+		if err := json.Unmarshal(data, &req); err != nil {
+			log.Println(err)
+			return
+		}
+		p.Do(func() {
+			if err := p.Control(&req); err != nil {
+				reply := p.state()
+				reply.Error = err.Error()
+				hub.send(reply, client)
+			}
+		})
+	})
+}
+
+// replay serves a recording through /ws until quit, without simulating
+func replay(path string) {
+	frames, err := LoadRecording(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("replay: %d frames, %s to %s", len(frames), frames[0].Time, frames[len(frames)-1].Time)
+
+	hub := newHub()
+	p := NewPlayer(frames, hub)
+	handleReplay(hub, p)
+	done := make(chan int)
+	go handleInput(done)
+	go hub.run()
+	go p.Run()
+	go func() {
+		http.Handle("/", http.StripPrefix("/", http.FileServer(http.Dir("client/"))))
+		http.HandleFunc("/ws", hub.handleWebSocket)
+		if err := http.ListenAndServe(":3000", nil); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	<-done
+}
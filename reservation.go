@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// how long a held stall waits past the end of the arrival window
+const ReservationGrace = 15 * time.Minute
+
+// how long a vehicle refused a stall waits before asking that charger again
+const RefusalBackoff = 15 * time.Minute
+
+// Reservation holds a stall at a charger for a vehicle arriving during
+// From to To. Status runs booked, then arrived, no-show or cancelled.
+type Reservation struct {
+	Id       string
+	Charger  *Charger
+	Vehicle  string // name or id
+	From, To time.Time
+	Status   string
+}
+
+func (r *Reservation) For(v *Vehicle) bool {
+	return r.Vehicle == v.Name || r.Vehicle == v.Id
+}
+
+// Holding, when the window has opened and the vehicle not yet arrived
+func (r *Reservation) Holding(now time.Time) bool {
+	return r.Status == "booked" && !now.Before(r.From)
+}
+
+// Reserve books a stall for vehicle arriving between from and to, if
+// bookings over that window leave one free.
+func (c *Charger) Reserve(vehicle string, from, to time.Time) (*Reservation, error) {
+	if !to.After(from) {
+		return nil, errors.New("reservation window is empty")
+	}
+	overlapping := 0
+	for _, r := range c.reservations {
+		if r.Status == "booked" && r.From.Before(to.Add(ReservationGrace)) && from.Before(r.To.Add(ReservationGrace)) {
+			overlapping++
+		}
+	}
+	if overlapping >= c.QueueCapacity {
+		return nil, fmt.Errorf("charger %s fully booked", c.Name)
+	}
+	r := &Reservation{
+		Id:      uuid.Must(uuid.NewV4()).String(),
+		Charger: c,
+		Vehicle: vehicle,
+		From:    from,
+		To:      to,
+		Status:  "booked",
+	}
+	c.reservations = append(c.reservations, r)
+	return r, nil
+}
+
+// Cancel a booking, releasing its stall
+func (c *Charger) Cancel(r *Reservation) {
+	if r.Status == "booked" {
+		r.Status = "cancelled"
+	}
+}
+
+// reservationFor finds v's booking, arriving in its window or grace period
+func (c *Charger) reservationFor(v *Vehicle) *Reservation {
+	now := c.world.Clock.Now()
+	for _, r := range c.reservations {
+		if r.Status == "booked" && r.For(v) && now.Before(r.To.Add(ReservationGrace)) {
+			return r
+		}
+	}
+	return nil
+}
+
+// held is the number of stalls held for bookings yet to arrive
+func (c *Charger) held() int {
+	now := c.world.Clock.Now()
+	n := 0
+	for _, r := range c.reservations {
+		if r.Holding(now) {
+			n++
+		}
+	}
+	return n
+}
+
+// ExpireReservations releases stalls held for no-shows, and forgets
+// bookings long since settled.
+func (c *Charger) ExpireReservations() {
+	now := c.world.Clock.Now()
+	keep := c.reservations[:0]
+	for _, r := range c.reservations {
+		if r.Status == "booked" && now.After(r.To.Add(ReservationGrace)) {
+			c.world.Event(Event{Kind: NoShowEvent, Id: r.Id, Vehicle: r.Vehicle, Charger: c.Name})
+			r.Status = "no-show"
+		}
+		if r.Status == "booked" || now.Before(r.To.Add(24*time.Hour)) {
+			keep = append(keep, r)
+		}
+	}
+	c.reservations = keep
+}
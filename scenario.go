@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Scenario is a simulation to run headless, from Start for Length, for
+// studies that need many runs: the track, its demand and the chargers to
+// place around it.
+type Scenario struct {
+	Start         time.Time
+	Step          time.Duration
+	Length        time.Duration // simulated
+	Seed          int64
+	Radius        float64
+	Elevation     Elevation
+	Temp          float64
+	SpawnRate     float64  // vehicles joining per hour at average demand
+	Vehicles      int      // most on the track at once
+	Chargers      []string // models, see the charger catalog
+	QueueCapacity int      // of the fast chargers, zero for the default
+}
+
+// Outcome of a run, from its metrics
+type Outcome struct {
+	Vehicles    int     // joined over the run
+	Flats       int     // gone flat
+	Visits      int     // to fast chargers
+	Wait        float64 // average minutes queued a visit
+	Utilization float64 // share of charger stall time in use, percent
+}
+
+// Build the scenario's track, the chargers at placement, radians round
+// the track. Chargers beyond the placement are left out, and without one
+// they're laid out at random.
+func (s *Scenario) Build(placement []float64) *CircularTrack {
+	world := NewWorld(NewClock(s.Start, s.Step), s.Seed)
+	world.Environment = FixedTemperature(s.Temp)
+
+	t := NewCircularTrack("T", Points{180.0, 135.0}, s.Radius)
+	t.SetWorld(world)
+	t.SetSpawner(NewSpawner(s.SpawnRate, s.Vehicles))
+	t.SetElevation(s.Elevation)
+
+	models := s.Chargers
+	if placement != nil {
+		models = models[:len(placement)]
+	}
+	chargers := []*Charger{}
+	for i, model := range models {
+		c := NewCharger(fmt.Sprintf("C%02d", i+1), model, "online")
+		if s.QueueCapacity > 0 && !c.AC {
+			c.QueueCapacity = s.QueueCapacity
+		}
+		t.Add(c)
+		chargers = append(chargers, c)
+	}
+	t.RandomizeObjects()
+	for i, theta := range placement {
+		t.Place(chargers[i], theta)
+	}
+	return t
+}
+
+// Run the scenario to the end with the chargers at placement
+func (s *Scenario) Run(placement []float64) *Outcome {
+	t := s.Build(placement)
+	world := t.World()
+	end := s.Start.Add(s.Length)
+	for world.Clock.Now().Before(end) {
+		t.Tick()
+	}
+	world.Close()
+
+	m := world.Metrics.Summary()
+	return &Outcome{
+		Vehicles:    m.Vehicles,
+		Flats:       m.Flats,
+		Visits:      m.Visits,
+		Wait:        m.Wait,
+		Utilization: m.Utilization,
+	}
+}
+
+func (o *Outcome) String() string {
+	return fmt.Sprintf("%d vehicles, %d flat, %d visits, %.1f min average wait, %.1f%% utilization",
+		o.Vehicles, o.Flats, o.Visits, o.Wait, o.Utilization)
+}
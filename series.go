@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Series is a time series of readings loaded from CSV, holding the first
+// and last readings outside the recorded period.
+type Series struct {
+	times  []time.Time
+	values []float64
+}
+
+// LoadSeries reads a CSV of timestamp,value rows. Timestamps are RFC 3339
+// or 2006-01-02T15:04 in local time, a header row is skipped. Readings
+// must be in time order, each after the one before.
+func LoadSeries(path string) (*Series, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	series := &Series{}
+	for i, row := range rows {
+		if len(row) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected timestamp,value", path, i+1)
+		}
+		t, terr := parseTimestamp(row[0])
+		v, verr := strconv.ParseFloat(row[1], 64)
+		if terr != nil || verr != nil {
+			if i == 0 {
+				continue // header
+			}
+			return nil, fmt.Errorf("%s:%d: bad reading %q", path, i+1, row)
+		}
+		if n := len(series.times); n > 0 && !t.After(series.times[n-1]) {
+			return nil, fmt.Errorf("%s:%d: %s is not after the reading before", path, i+1, row[0])
+		}
+		series.times = append(series.times, t)
+		series.values = append(series.values, v)
+	}
+	if len(series.times) == 0 {
+		return nil, fmt.Errorf("%s: no readings", path)
+	}
+	return series, nil
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02T15:04", s, time.Local)
+}
+
+// Interpolate between the readings either side of t
+func (s *Series) Interpolate(t time.Time) float64 {
+	i := s.after(t)
+	if i == 0 {
+		return s.values[0]
+	}
+	if i == len(s.times) {
+		return s.values[i-1]
+	}
+	span := s.times[i].Sub(s.times[i-1]).Seconds()
+	frac := t.Sub(s.times[i-1]).Seconds() / span
+	return s.values[i-1] + (s.values[i]-s.values[i-1])*frac
+}
+
+// Step is the latest reading at or before t
+func (s *Series) Step(t time.Time) float64 {
+	i := s.after(t)
+	if i == 0 {
+		return s.values[0]
+	}
+	return s.values[i-1]
+}
+
+// index of the first reading after t
+func (s *Series) after(t time.Time) int {
+	return sort.Search(len(s.times), func(i int) bool {
+		return s.times[i].After(t)
+	})
+}
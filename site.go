@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/rhymond/go-money"
+)
+
+// EnergyPrice is what a site pays for electricity, in minor units per kWh
+type EnergyPrice interface {
+	Price(t time.Time) float64
+}
+
+// FlatEnergy is the same price around the clock
+type FlatEnergy float64
+
+func (f FlatEnergy) Price(t time.Time) float64 {
+	return float64(f)
+}
+
+// TimeOfUseEnergy replaces Base during each period, the last matching
+// period winning.
+type TimeOfUseEnergy struct {
+	Base    float64
+	Periods []PricePeriod
+}
+
+func (e *TimeOfUseEnergy) Price(t time.Time) float64 {
+	price := e.Base
+	for _, period := range e.Periods {
+		if period.Covers(t.Hour()) {
+			price = float64(period.PerKWh)
+		}
+	}
+	return price
+}
+
+// WholesaleEnergy follows a series of hourly wholesale prices
+type WholesaleEnergy struct {
+	*Series
+}
+
+func LoadWholesaleEnergy(path string) (*WholesaleEnergy, error) {
+	s, err := LoadSeries(path)
+	if err != nil {
+		return nil, err
+	}
+	return &WholesaleEnergy{s}, nil
+}
+
+func (e *WholesaleEnergy) Price(t time.Time) float64 {
+	return e.Step(t)
+}
+
+// NewEnergyPrice builds a site energy price by name: flat at base, tou
+// (cheap overnight, dear in the evening peak) or the path of a CSV of
+// timestamp,price rows.
+func NewEnergyPrice(name string, base float64) (EnergyPrice, error) {
+	switch name {
+	case "flat":
+		return FlatEnergy(base), nil
+	case "tou":
+		return &TimeOfUseEnergy{
+			Base: base,
+			Periods: []PricePeriod{
+				{From: 22, To: 7, PerKWh: int64(math.Round(base * 0.5))},
+				{From: 17, To: 21, PerKWh: int64(math.Round(base * 2))},
+			},
+		}, nil
+	}
+	return LoadWholesaleEnergy(name)
+}
+
+// Site is a grid connection shared by one or more chargers. It pays the
+// utility for the energy drawn, and a demand charge on the peak draw in
+// each monthly billing period. A site may have its own solar array and
+// battery, dispatched ahead of or behind the grid, and a limit on what the
+// connection can carry.
+type Site struct {
+	Name         string
+	Currency     string
+	Energy       EnergyPrice
+	DemandCharge int64   // per kW of peak draw per billing period
+	GridKW       float64 // connection limit, zero for none
+	Solar        Solar   // nil for none
+	Battery      *Battery
+	Dispatch     DispatchPolicy
+
+	KWh           float64
+	EnergyCost    *money.Money // paid so far
+	DemandCharges *money.Money // paid for closed billing periods
+	Peak          float64      // kW net of exports, in the open billing period
+	ExportedKWh   float64
+	ExportCredit  *money.Money // paid by the utility for exports so far
+
+	// energy by source, and demand the site couldn't meet
+	SolarKWh    float64
+	BatteryKWh  float64
+	GridKWh     float64
+	SpilledKWh  float64
+	UnservedKWh float64
+	GridPeak    float64 // kW imported, over the run
+
+	period    string
+	drawn     float64 // kWh this tick
+	exported  float64 // kWh this tick
+	owed      float64 // energy cost under a minor unit, not yet paid
+	earned    float64 // export credit under a minor unit, not yet paid
+	tick      time.Time
+	budget    float64     // kW left to share out this tick
+	hourly    [24]float64 // kWh imported by hour of day, for a baseline
+	days      [24]float64 // hours of each hour of day run
+	curtail   float64     // kW limit on import during a demand response event
+	curtailed bool
+}
+
+func NewSite(name, currency string, energy EnergyPrice, demandCharge int64) *Site {
+	return &Site{
+		Name:          name,
+		Currency:      currency,
+		Energy:        energy,
+		DemandCharge:  demandCharge,
+		EnergyCost:    money.New(0, currency),
+		DemandCharges: money.New(0, currency),
+		ExportCredit:  money.New(0, currency),
+		Dispatch:      SolarFirst{},
+	}
+}
+
+func (s *Site) solar(now time.Time) float64 {
+	if s.Solar == nil {
+		return 0
+	}
+	return s.Solar.Output(now)
+}
+
+// Baseline is the site's usual grid draw in kW between from and to, from
+// its average draw at those hours of the day so far. False until the run
+// has seen a full hour of them.
+func (s *Site) Baseline(from, to time.Time) (float64, bool) {
+	var kWh, hours float64
+	for t := from.Truncate(time.Hour); t.Before(to); t = t.Add(time.Hour) {
+		kWh += s.hourly[t.Hour()]
+		hours += s.days[t.Hour()]
+	}
+	if hours < 1 {
+		return 0, false
+	}
+	return kWh / hours, true
+}
+
+// Curtail grid import to kW, for a demand response event
+func (s *Site) Curtail(kW float64) {
+	s.curtail, s.curtailed = kW, true
+}
+
+// Release the site from curtailment
+func (s *Site) Release() {
+	s.curtailed = false
+}
+
+// Limit on grid import in kW, the connection's or tighter during a demand
+// response event. Infinite for neither.
+func (s *Site) Limit() float64 {
+	limit := math.Inf(1)
+	if s.GridKW > 0 {
+		limit = s.GridKW
+	}
+	if s.curtailed {
+		limit = math.Min(limit, s.curtail)
+	}
+	return limit
+}
+
+// Capacity in kW the site can supply now: its grid connection, solar and
+// battery. Unlimited without a limit on the grid.
+func (s *Site) Capacity(now time.Time, hours float64) float64 {
+	limit := s.Limit()
+	if math.IsInf(limit, 1) {
+		return limit
+	}
+	kW := limit + s.solar(now)
+	if s.Battery != nil {
+		kW += s.Battery.Available(hours)
+	}
+	return kW
+}
+
+// Allocate up to kW to a charger for the tick at now, first come first
+// served, and counts what it can't have as unserved.
+func (s *Site) Allocate(kW float64, now time.Time, hours float64) float64 {
+	if !now.Equal(s.tick) {
+		s.tick = now
+		s.budget = s.Capacity(now, hours)
+	}
+	granted := math.Max(0, math.Min(kW, s.budget))
+	s.budget -= granted
+	s.UnservedKWh += (kW - granted) * hours
+	return granted
+}
+
+// Draw kWh for the chargers during this tick
+func (s *Site) Draw(kWh float64) {
+	s.drawn += kWh
+}
+
+// Export kWh to the grid during this tick, from vehicles discharging
+func (s *Site) Export(kWh float64) {
+	s.exported += kWh
+}
+
+// Settle the tick ending now, taking clock.Step to draw the tick's energy,
+// and paying the utility in whole minor units.
+func (s *Site) Settle(clock *Clock, ledger *Ledger) {
+	now := clock.Now()
+	if period := now.Format("2006-01"); period != s.period {
+		s.CloseBillingPeriod(now, ledger)
+		s.period = period
+	}
+	f := s.Dispatch.Dispatch(s, now, s.drawn, clock.Hours())
+	exported := s.exported + f.Spill
+	kW := (f.Import - exported) / clock.Hours()
+	s.Peak = math.Max(s.Peak, kW)
+	s.GridPeak = math.Max(s.GridPeak, f.Import/clock.Hours())
+	s.hourly[now.Hour()] += f.Import
+	s.days[now.Hour()] += clock.Hours()
+	s.KWh += s.drawn
+	s.SolarKWh += f.Solar
+	s.BatteryKWh += f.Discharge
+	s.GridKWh += f.Import
+	s.SpilledKWh += f.Spill
+	s.ExportedKWh += exported
+	s.owed += f.Import * s.Energy.Price(now)
+	s.earned += exported * s.Energy.Price(now)
+	s.drawn, s.exported = 0, 0
+
+	if whole := math.Floor(s.owed); whole >= 1 {
+		s.owed -= whole
+		s.pay(now, ledger, &s.EnergyCost, int64(whole), "energy")
+	}
+	if whole := math.Floor(s.earned); whole >= 1 {
+		s.earned -= whole
+		m := money.New(int64(whole), s.Currency)
+		if err := ledger.Post(now, UtilityAccount, OperatorAccount(s.Name), m, "export"); err != nil {
+			log.Println(err)
+			return
+		}
+		s.ExportCredit, _ = s.ExportCredit.Add(m)
+	}
+}
+
+// CloseBillingPeriod pays the demand charge for the open period's peak
+func (s *Site) CloseBillingPeriod(now time.Time, ledger *Ledger) {
+	charge := int64(math.Round(s.Peak * float64(s.DemandCharge)))
+	s.pay(now, ledger, &s.DemandCharges, charge, "demand charge "+s.period)
+	s.Peak = 0
+}
+
+func (s *Site) pay(now time.Time, ledger *Ledger, total **money.Money, amount int64, memo string) {
+	m := money.New(amount, s.Currency)
+	if err := ledger.Post(now, OperatorAccount(s.Name), UtilityAccount, m, memo); err != nil {
+		log.Println(err)
+		return
+	}
+	*total, _ = (*total).Add(m)
+}
+
+// Costs paid so far, and the demand charge due for the open billing
+// period's peak to date.
+func (s *Site) Costs() (energy, demand *money.Money) {
+	open := money.New(int64(math.Round(s.Peak*float64(s.DemandCharge))), s.Currency)
+	demand, _ = s.DemandCharges.Add(open)
+	return s.EnergyCost, demand
+}
+
+// ProfitReport writes each site's profit and loss as CSV: revenue billed
+// in the site's currency, less payouts to drivers for discharging, its
+// energy costs and demand charges, plus the utility's credit for exports.
+// Revenue billed in any other currency is left out.
+func ProfitReport(w io.Writer, sites []*Site, billing *Billing) error {
+	revenue := make(map[string]*money.Money)
+	payouts := make(map[string]*money.Money)
+	for _, r := range billing.Revenue() {
+		site := revenue[r.Site]
+		if site == nil {
+			site = money.New(0, r.Amount.Currency().Code)
+		}
+		if sum, err := site.Add(r.Amount); err == nil {
+			revenue[r.Site] = sum
+		}
+		paid := payouts[r.Site]
+		if paid == nil {
+			paid = money.New(0, r.Payouts.Currency().Code)
+		}
+		if sum, err := paid.Add(r.Payouts); err == nil {
+			payouts[r.Site] = sum
+		}
+	}
+
+	sort.Slice(sites, func(i, j int) bool {
+		return sites[i].Name < sites[j].Name
+	})
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"site", "kwh", "revenue", "payouts", "energy", "demand", "export", "profit", "currency"})
+	for _, s := range sites {
+		income := revenue[s.Name]
+		if income == nil || income.Currency().Code != s.Currency {
+			income = money.New(0, s.Currency)
+		}
+		paid := payouts[s.Name]
+		if paid == nil || paid.Currency().Code != s.Currency {
+			paid = money.New(0, s.Currency)
+		}
+		energy, demand := s.Costs()
+		profit, _ := income.Subtract(paid)
+		profit, _ = profit.Subtract(energy)
+		profit, _ = profit.Subtract(demand)
+		profit, _ = profit.Add(s.ExportCredit)
+		out.Write([]string{
+			s.Name,
+			fmt.Sprintf("%.3f", s.KWh),
+			fmt.Sprintf("%d", income.Amount()),
+			fmt.Sprintf("%d", paid.Amount()),
+			fmt.Sprintf("%d", energy.Amount()),
+			fmt.Sprintf("%d", demand.Amount()),
+			fmt.Sprintf("%d", s.ExportCredit.Amount()),
+			fmt.Sprintf("%d", profit.Amount()),
+			s.Currency,
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Need is what a plugged in fleet vehicle has to be given before it
+// leaves, and the profile planned to give it: kW in each slot.
+type Need struct {
+	Vehicle  *FleetVehicle
+	KWh      float64 // still to plan
+	MaxKW    float64
+	Deadline int     // slots before departure
+	Last     float64 // share of the last slot before departure
+	Profile  []float64
+}
+
+// room for more kW in slot, averaged over the whole slot
+func (n *Need) room(slot int) float64 {
+	switch {
+	case slot >= n.Deadline:
+		return 0
+	case slot == n.Deadline-1:
+		return n.MaxKW*n.Last - n.Profile[slot]
+	}
+	return n.MaxKW - n.Profile[slot]
+}
+
+// Rate in kW to charge at in slot, to give its energy before departure
+func (n *Need) Rate(slot int) float64 {
+	if slot >= len(n.Profile) || slot >= n.Deadline {
+		return 0
+	}
+	if slot == n.Deadline-1 && n.Last > 0 {
+		return n.Profile[slot] / n.Last
+	}
+	return n.Profile[slot]
+}
+
+// Slots are the planning horizon: the start, price and kW the site can
+// supply in each.
+type Slots struct {
+	Length   time.Duration
+	Start    []time.Time
+	Price    []float64
+	Capacity []float64
+}
+
+func (s *Slots) hours() float64 {
+	return s.Length.Hours()
+}
+
+// Load is the kW planned in slot across every need
+func Load(needs []*Need, slot int) float64 {
+	kW := 0.0
+	for _, n := range needs {
+		kW += n.Profile[slot]
+	}
+	return kW
+}
+
+// DepotScheduler plans the power profile of every vehicle at a depot
+// across the night, filling in each Need's Profile within the slots'
+// capacity. A need it can't meet is left short.
+type DepotScheduler interface {
+	Plan(needs []*Need, slots *Slots)
+}
+
+// NewDepotScheduler by name: edf, price or peak. Defaults to edf.
+func NewDepotScheduler(name string) DepotScheduler {
+	switch name {
+	case "price":
+		return PriceOptimal{}
+	case "peak":
+		return PeakShaving{}
+	}
+	return EarliestDeadline{}
+}
+
+func byDeadline(needs []*Need) []*Need {
+	sorted := append([]*Need(nil), needs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Deadline < sorted[j].Deadline
+	})
+	return sorted
+}
+
+// EarliestDeadline charges as soon as it can, the vehicle leaving first
+// taking the power first.
+type EarliestDeadline struct{}
+
+func (EarliestDeadline) Plan(needs []*Need, slots *Slots) {
+	needs = byDeadline(needs)
+	h := slots.hours()
+	for i := range slots.Start {
+		left := slots.Capacity[i]
+		for _, n := range needs {
+			kW := math.Min(math.Min(n.room(i), n.KWh/h), left)
+			if kW <= 0 {
+				continue
+			}
+			n.Profile[i] += kW
+			n.KWh -= kW * h
+			left -= kW
+		}
+	}
+}
+
+// PriceOptimal charges each vehicle in the cheapest slots before it
+// leaves, in order of departure.
+type PriceOptimal struct{}
+
+func (PriceOptimal) Plan(needs []*Need, slots *Slots) {
+	h := slots.hours()
+	for _, n := range byDeadline(needs) {
+		order := make([]int, n.Deadline)
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool {
+			return slots.Price[order[a]] < slots.Price[order[b]]
+		})
+		for _, i := range order {
+			left := slots.Capacity[i] - Load(needs, i)
+			kW := math.Min(math.Min(n.room(i), n.KWh/h), left)
+			if kW <= 0 {
+				continue
+			}
+			n.Profile[i] += kW
+			n.KWh -= kW * h
+		}
+	}
+}
+
+// PeakShaving levels the depot's load across the night, each vehicle in
+// order of departure topping up whichever of its slots is lowest.
+type PeakShaving struct{}
+
+// a tenth of a vehicle's power at a time
+const peakShavingSteps = 10
+
+func (PeakShaving) Plan(needs []*Need, slots *Slots) {
+	h := slots.hours()
+	load := make([]float64, len(slots.Start))
+	for _, n := range byDeadline(needs) {
+		step := n.MaxKW / peakShavingSteps
+		for n.KWh > 1e-9 {
+			best := -1
+			for i := 0; i < n.Deadline; i++ {
+				if n.room(i) <= 0 || load[i] >= slots.Capacity[i] {
+					continue
+				}
+				if best < 0 || load[i] < load[best] {
+					best = i
+				}
+			}
+			if best < 0 {
+				break
+			}
+			kW := math.Min(math.Min(step, n.room(best)), math.Min(n.KWh/h, slots.Capacity[best]-load[best]))
+			n.Profile[best] += kW
+			n.KWh -= kW * h
+			load[best] += kW
+		}
+	}
+}
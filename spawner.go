@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Spawner adds vehicles to a Track over time, following the Demand spawn
+// profile.
+type Spawner struct {
+	Rate float64 // vehicles per hour at a factor of 1.0
+	Max  int     // stop once the track holds this many vehicles
+	due  float64
+	n    int
+}
+
+func NewSpawner(rate float64, max int) *Spawner {
+	return &Spawner{
+		Rate: rate,
+		Max:  max,
+	}
+}
+
+// Spawn returns the vehicles joining during this tick, with count the
+// vehicles already on the track.
+func (s *Spawner) Spawn(w *World, count int) []*Vehicle {
+	now := w.Clock.Now()
+	s.due += s.Rate * w.Demand.Spawn.Factor(now) * w.Clock.Hours()
+
+	vehicles := []*Vehicle{}
+	for ; s.due >= 1.0; s.due-- {
+		if count+len(vehicles) >= s.Max {
+			continue
+		}
+		s.n++
+		model := vehicleCatalog[w.Rand.Intn(len(vehicleCatalog))].Model
+		name := fmt.Sprintf("S%02d", s.n)
+
+		// drivers plugged in overnight set out full
+		charge := 20 + w.Rand.Float64()*60
+		if w.Rand.Float64() < w.Demand.HomeCharging.Factor(now) {
+			charge = 99.0
+		}
+		v := NewVehicle(name, model, "drive", charge)
+		v.Velocity = w.Rand.Float64() + 0.5 // from the world, so a seed replays the same
+		if w.Rand.Float64() < w.Demand.PriceSensitive {
+			v.Strategy = CheapestCharger{Detour: 0.1, Below: 50}
+		}
+		v.Books = w.Rand.Float64() < w.Demand.Booking
+		v.MinSoC = 30 + w.Rand.Float64()*40
+		vehicles = append(vehicles, v)
+	}
+	return vehicles
+}
@@ -0,0 +1,62 @@
+package main
+
+import "math"
+
+// DriverStrategy picks the charger to head for from the hints ordered
+// nearest first, asked every tick while driving: nil keeps going.
+type DriverStrategy interface {
+	Choose(v *Vehicle, hints []*Hint) *Hint
+}
+
+// NearestCharger heads for the closest charger once it can't make the
+// one after.
+type NearestCharger struct{}
+
+func (s NearestCharger) Choose(v *Vehicle, hints []*Hint) *Hint {
+	switch {
+	case len(hints) == 0:
+		// oh dear, keeping going...
+		return nil
+	case len(hints) == 1:
+		// we can make it all the way around, or at least there
+		if hints[0].NextRange || hints[0].InRange {
+			return nil
+		}
+	case hints[1].InRange:
+		// we can make it to the charger after nearest
+		return nil
+	}
+	return hints[0]
+}
+
+// CheapestCharger starts looking once charge drops Below, trading price
+// per kWh against the distance to every charger it can still reach ahead.
+// Left with none, it heads for the nearest as it must.
+type CheapestCharger struct {
+	Detour float64 // price per kWh worth travelling one unit further
+	Below  float64 // percent
+}
+
+func (s CheapestCharger) Choose(v *Vehicle, hints []*Hint) *Hint {
+	must := NearestCharger{}.Choose(v, hints)
+	if must == nil && v.Charge >= s.Below {
+		return nil
+	}
+	var best *Hint
+	score := math.Inf(1)
+	for _, h := range hints {
+		if !h.InRange && !h.NextRange {
+			continue
+		}
+		if math.Signbit(h.Vector) != math.Signbit(v.Velocity) {
+			continue
+		}
+		if sc := float64(h.Price) + s.Detour*h.Dist; sc < score {
+			best, score = h, sc
+		}
+	}
+	if best == nil {
+		return must
+	}
+	return best
+}
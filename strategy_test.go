@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestCheapestCharger(t *testing.T) {
+	near := &Hint{Dist: 10, Vector: 1, Price: 60, InRange: true, NextRange: true}
+	cheap := &Hint{Dist: 100, Vector: 1, Price: 30, InRange: true}
+	behind := &Hint{Dist: 120, Vector: -1, Price: 10, InRange: true}
+	unreachable := &Hint{Dist: 150, Vector: 1, Price: 5}
+
+	tests := []struct {
+		name   string
+		charge float64
+		hints  []*Hint
+		want   *Hint
+	}{
+		{"cheaper further on wins", 40, []*Hint{near, cheap}, cheap},
+		{"not worth the detour", 40, []*Hint{near, {Dist: 500, Vector: 1, Price: 55, InRange: true}}, near},
+		{"never turns back for price", 40, []*Hint{near, behind}, near},
+		{"out of range", 40, []*Hint{near, unreachable}, near},
+		{"charged enough to keep going", 80, []*Hint{near, cheap}, nil},
+		{"must charge, only the nearest reachable", 80, []*Hint{near, {Dist: 80, Vector: 1, Price: 30}}, near},
+		{"no chargers", 10, nil, nil},
+	}
+	s := CheapestCharger{Detour: 0.1, Below: 50}
+	for _, tt := range tests {
+		v := NewVehicle("V", "Leaf", "drive", tt.charge)
+		v.Velocity = 1
+		if got := s.Choose(v, tt.hints); got != tt.want {
+			t.Errorf("%s: chose %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNearestCharger(t *testing.T) {
+	near := &Hint{Dist: 10, Vector: -1, Price: 60, InRange: true}
+	next := &Hint{Dist: 100, Vector: 1, Price: 30, InRange: true}
+	v := NewVehicle("V", "Leaf", "drive", 40)
+	v.Velocity = 1
+	if got := (NearestCharger{}).Choose(v, []*Hint{near, next}); got != nil {
+		t.Errorf("chose %+v with the next in range, want to keep going", got)
+	}
+	next.InRange = false
+	if got := (NearestCharger{}).Choose(v, []*Hint{near, next}); got != near {
+		t.Errorf("chose %+v, want the nearest", got)
+	}
+}
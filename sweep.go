@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Sweep runs a base scenario for every combination of vehicles, chargers
+// and queue capacity, each over every seed, spread over Workers at once.
+// A charger count cycles through the base scenario's Chargers for models.
+type Sweep struct {
+	Base     Scenario
+	Vehicles []int
+	Chargers []int
+	Queues   []int
+	Seeds    []int64
+	Workers  int
+}
+
+// Result of a combination, an outcome for each seed
+type Result struct {
+	Vehicles int        `json:"max_vehicles"`
+	Chargers int        `json:"chargers"`
+	Queue    int        `json:"queue"`
+	Seeds    []int64    `json:"seeds"`
+	Outcomes []*Outcome `json:"-"`
+}
+
+// Run every combination, returning a Result for each in order
+func (s *Sweep) Run() []*Result {
+	results := []*Result{}
+	for _, vehicles := range s.Vehicles {
+		for _, chargers := range s.Chargers {
+			for _, queue := range s.Queues {
+				results = append(results, &Result{
+					Vehicles: vehicles,
+					Chargers: chargers,
+					Queue:    queue,
+					Seeds:    s.Seeds,
+					Outcomes: make([]*Outcome, len(s.Seeds)),
+				})
+			}
+		}
+	}
+
+	type run struct {
+		r    *Result
+		seed int
+	}
+	runs := make(chan run)
+	total := len(results) * len(s.Seeds)
+	var mu sync.Mutex
+	done := 0
+	var wg sync.WaitGroup
+	for i := 0; i < s.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for next := range runs {
+				o := s.scenario(next.r, next.r.Seeds[next.seed]).Run(nil)
+				mu.Lock()
+				next.r.Outcomes[next.seed] = o
+				done++
+				log.Printf("sweep: %d/%d, %d vehicles, %d chargers, queue %d, seed %d: %s",
+					done, total, next.r.Vehicles, next.r.Chargers, next.r.Queue, next.r.Seeds[next.seed], o)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, r := range results {
+		for i := range s.Seeds {
+			runs <- run{r, i}
+		}
+	}
+	close(runs)
+	wg.Wait()
+	return results
+}
+
+// scenario for a combination and seed
+func (s *Sweep) scenario(r *Result, seed int64) *Scenario {
+	scenario := s.Base
+	scenario.Seed = seed
+	scenario.Vehicles = r.Vehicles
+	scenario.QueueCapacity = r.Queue
+	scenario.Chargers = make([]string, r.Chargers)
+	for i := range scenario.Chargers {
+		scenario.Chargers[i] = s.Base.Chargers[i%len(s.Base.Chargers)]
+	}
+	return &scenario
+}
+
+// Stat is a metric's mean across seeds, give or take the half width of
+// its 95% confidence interval.
+type Stat struct {
+	Mean float64 `json:"mean"`
+	CI   float64 `json:"ci95"`
+}
+
+func NewStat(values []float64) Stat {
+	n := float64(len(values))
+	if n == 0 {
+		return Stat{}
+	}
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean = mean / n
+	if n < 2 {
+		return Stat{Mean: mean}
+	}
+	ss := 0.0
+	for _, v := range values {
+		ss += (v - mean) * (v - mean)
+	}
+	sd := math.Sqrt(ss / (n - 1))
+	return Stat{Mean: mean, CI: studentT(len(values)-1) * sd / math.Sqrt(n)}
+}
+
+// two sided 95% critical values of Student's t, by degrees of freedom
+var tTable = []float64{12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042}
+
+func studentT(df int) float64 {
+	if df > len(tTable) {
+		return 1.96
+	}
+	return tTable[df-1]
+}
+
+// Metrics across seeds
+func (r *Result) Metrics() map[string]Stat {
+	metric := func(f func(o *Outcome) float64) Stat {
+		values := []float64{}
+		for _, o := range r.Outcomes {
+			values = append(values, f(o))
+		}
+		return NewStat(values)
+	}
+	return map[string]Stat{
+		"vehicles":    metric(func(o *Outcome) float64 { return float64(o.Vehicles) }),
+		"flats":       metric(func(o *Outcome) float64 { return float64(o.Flats) }),
+		"visits":      metric(func(o *Outcome) float64 { return float64(o.Visits) }),
+		"wait":        metric(func(o *Outcome) float64 { return o.Wait }),
+		"utilization": metric(func(o *Outcome) float64 { return o.Utilization }),
+	}
+}
+
+// in the order of the report's columns
+var sweepMetrics = []string{"vehicles", "flats", "visits", "wait", "utilization"}
+
+// SweepReport writes a row for each combination as CSV, every metric's
+// mean and confidence interval across the seeds.
+func SweepReport(w io.Writer, results []*Result) error {
+	out := csv.NewWriter(w)
+	header := []string{"max_vehicles", "chargers", "queue", "seeds"}
+	for _, m := range sweepMetrics {
+		header = append(header, m, m+"_ci95")
+	}
+	out.Write(header)
+	for _, r := range results {
+		row := []string{
+			strconv.Itoa(r.Vehicles),
+			strconv.Itoa(r.Chargers),
+			strconv.Itoa(r.Queue),
+			strconv.Itoa(len(r.Seeds)),
+		}
+		stats := r.Metrics()
+		for _, m := range sweepMetrics {
+			row = append(row, fmt.Sprintf("%.3f", stats[m].Mean), fmt.Sprintf("%.3f", stats[m].CI))
+		}
+		out.Write(row)
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// SweepJSON writes the same table as JSON, with every seed's outcome
+func SweepJSON(w io.Writer, results []*Result) error {
+	type outcome struct {
+		Seed        int64   `json:"seed"`
+		Vehicles    int     `json:"vehicles"`
+		Flats       int     `json:"flats"`
+		Visits      int     `json:"visits"`
+		Wait        float64 `json:"wait"`
+		Utilization float64 `json:"utilization"`
+	}
+	type row struct {
+		*Result
+		Metrics map[string]Stat `json:"metrics"`
+		Runs    []outcome       `json:"runs"`
+	}
+	rows := []row{}
+	for _, r := range results {
+		runs := []outcome{}
+		for i, o := range r.Outcomes {
+			runs = append(runs, outcome{r.Seeds[i], o.Vehicles, o.Flats, o.Visits, o.Wait, o.Utilization})
+		}
+		rows = append(rows, row{r, r.Metrics(), runs})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", " ")
+	return enc.Encode(rows)
+}
+
+// ParseRange reads a comma separated list of numbers or from:to:step
+// ranges, like 4,8:16:4 for 4, 8, 12 and 16, none of them below min.
+func ParseRange(s string, min int) ([]int, error) {
+	values := []int{}
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.Split(strings.TrimSpace(part), ":")
+		nums := []int{}
+		for _, b := range bounds {
+			n, err := strconv.Atoi(b)
+			if err != nil {
+				return nil, fmt.Errorf("bad range %q", s)
+			}
+			if n < min {
+				return nil, fmt.Errorf("%d in %q is below %d", n, s, min)
+			}
+			nums = append(nums, n)
+		}
+		switch len(nums) {
+		case 1:
+			values = append(values, nums[0])
+		case 2, 3:
+			step := 1
+			if len(nums) == 3 {
+				step = nums[2]
+			}
+			if step <= 0 || nums[1] < nums[0] {
+				return nil, fmt.Errorf("bad range %q", part)
+			}
+			for n := nums[0]; n <= nums[1]; n += step {
+				values = append(values, n)
+			}
+		default:
+			return nil, fmt.Errorf("bad range %q", part)
+		}
+	}
+	return values, nil
+}
+
+// runSweep runs the sweep headless, writing the table to path, as JSON
+// for a .json file and CSV otherwise, or to stdout.
+func runSweep(s *Sweep, path string) {
+	table := s.Run()
+
+	report := SweepReport
+	if strings.HasSuffix(path, ".json") {
+		report = SweepJSON
+	}
+	writeReport(path, func(w io.Writer) error {
+		return report(w, table)
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		in      string
+		min     int
+		want    []int
+		wantErr bool
+	}{
+		{"4", 0, []int{4}, false},
+		{"4,8:16:4", 0, []int{4, 8, 12, 16}, false},
+		{"1:3", 0, []int{1, 2, 3}, false},
+		{"5:5", 0, []int{5}, false},
+		{"1:10:4", 0, []int{1, 5, 9}, false},
+		{" 2 , 3", 0, []int{2, 3}, false},
+		{"0", 0, []int{0}, false},
+		{"-2:1", -2, []int{-2, -1, 0, 1}, false},
+		{"-2", 0, nil, true},
+		{"-2:2", 0, nil, true},
+		{"4,-1", 0, nil, true},
+		{"16:8", 0, nil, true},
+		{"1:5:0", 0, nil, true},
+		{"1:5:-1", 0, nil, true},
+		{"1:2:3:4", 0, nil, true},
+		{"a", 0, nil, true},
+		{"", 0, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRange(tt.in, tt.min)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRange(%q, %d) error %v, want error %v", tt.in, tt.min, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseRange(%q, %d) = %v, want %v", tt.in, tt.min, got, tt.want)
+		}
+	}
+}
+
+func TestNewStat(t *testing.T) {
+	many := make([]float64, 32)
+	for i := range many {
+		many[i] = float64(i)
+	}
+	// sample standard deviation of 0..31
+	sd := math.Sqrt(32 * 33 / 12.0)
+
+	tests := []struct {
+		name   string
+		values []float64
+		want   Stat
+	}{
+		{"none", nil, Stat{}},
+		{"one", []float64{7}, Stat{Mean: 7}},
+		{"same", []float64{3, 3, 3}, Stat{Mean: 3}},
+		{"three", []float64{1, 2, 3}, Stat{Mean: 2, CI: 4.303 / math.Sqrt(3)}},
+		{"beyond the table", many, Stat{Mean: 15.5, CI: 1.96 * sd / math.Sqrt(32)}},
+	}
+	for _, tt := range tests {
+		got := NewStat(tt.values)
+		if math.Abs(got.Mean-tt.want.Mean) > 1e-9 || math.Abs(got.CI-tt.want.CI) > 1e-9 {
+			t.Errorf("%s: NewStat = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStudentT(t *testing.T) {
+	tests := []struct {
+		df   int
+		want float64
+	}{
+		{1, 12.706},
+		{2, 4.303},
+		{10, 2.228},
+		{30, 2.042},
+		{31, 1.96},
+		{1000, 1.96},
+	}
+	for _, tt := range tests {
+		if got := studentT(tt.df); got != tt.want {
+			t.Errorf("studentT(%d) = %v, want %v", tt.df, got, tt.want)
+		}
+	}
+}
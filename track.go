@@ -6,8 +6,8 @@ import (
 	"github.com/rooprob/chargesim/message"
 	uuid "github.com/satori/go.uuid"
 	"math"
-	"math/rand"
 	"sort"
+	"time"
 )
 
 type Track interface {
@@ -18,6 +18,16 @@ type Track interface {
 	Render(render chan Object)
 }
 
+// FindCharger on the track by id or name
+func FindCharger(t Track, key string) *Charger {
+	for _, val := range t.Childs() {
+		if c, ok := val.(*Charger); ok && (c.Id == key || c.Name == key) {
+			return c
+		}
+	}
+	return nil
+}
+
 type StraightLineTrack struct {
 	// track parameters to describe a circle
 	Id     string
@@ -28,6 +38,7 @@ type StraightLineTrack struct {
 	end    Points
 	childs []Object
 	points []Points
+	world  *World
 }
 
 func NewStraightLineTrack(name string, origin Points, end Points) *StraightLineTrack {
@@ -43,9 +54,17 @@ func NewStraightLineTrack(name string, origin Points, end Points) *StraightLineT
 
 // Adds an element to the tree branch
 func (self *StraightLineTrack) Add(child Object) {
+	child.SetWorld(self.world)
 	self.childs = append(self.childs, child)
 }
 
+func (self *StraightLineTrack) SetWorld(w *World) {
+	self.world = w
+	for _, val := range self.childs {
+		val.SetWorld(w)
+	}
+}
+
 // Returns the child elements
 func (self *StraightLineTrack) Childs() []Object {
 	return self.childs
@@ -79,16 +98,19 @@ func (self *StraightLineTrack) Tick() {
 
 type CircularTrack struct {
 	// track parameters to describe a circle
-	Id     string
-	Color  string
-	Name   string
-	Kind   int
-	origin Points
-	radius float64
-	childs []Object
-	points []Points
-	rads   []float64
-	hints  []float64
+	Id        string
+	Color     string
+	Name      string
+	Kind      int
+	origin    Points
+	radius    float64
+	childs    []Object
+	points    []Points
+	rads      []float64
+	hints     []float64
+	world     *World
+	spawn     *Spawner
+	elevation Elevation
 }
 
 func NewCircularTrack(name string, origin Points, radius float64) *CircularTrack {
@@ -99,6 +121,7 @@ func NewCircularTrack(name string, origin Points, radius float64) *CircularTrack
 		Name:   name,
 		origin: origin,
 		radius: radius,
+		world:  NewWorld(NewClock(time.Now().Truncate(time.Hour), time.Minute), 42),
 	}
 }
 
@@ -110,6 +133,8 @@ func (v *CircularTrack) MarshalJSON() ([]byte, error) {
 		Origin Points  `json:"origin"`
 		Radius float64 `json:"radius"`
 		Name   string  `json:"name"`
+		Time   string  `json:"time"`
+		Temp   float64 `json:"temperature"`
 	}{
 		Id:     v.Id,
 		Color:  v.Color,
@@ -117,12 +142,38 @@ func (v *CircularTrack) MarshalJSON() ([]byte, error) {
 		Origin: v.origin,
 		Radius: v.radius,
 		Name:   v.Name,
+		Time:   v.world.Clock.Now().Format("Mon 2006-01-02 15:04"),
+		Temp:   v.world.Temperature(),
 	})
 }
 
 // Adds an element to the tree branch
 func (self *CircularTrack) Add(child Object) {
+	child.SetWorld(self.world)
 	self.childs = append(self.childs, child)
+	// joining after the layout, start at a random spot
+	if self.rads != nil {
+		self.rads = append(self.rads, self.world.Rand.Float64()*2*math.Pi)
+	}
+}
+
+func (self *CircularTrack) SetWorld(w *World) {
+	self.world = w
+	for _, val := range self.childs {
+		val.SetWorld(w)
+	}
+}
+
+func (self *CircularTrack) World() *World {
+	return self.world
+}
+
+func (self *CircularTrack) SetSpawner(s *Spawner) {
+	self.spawn = s
+}
+
+func (self *CircularTrack) SetElevation(e Elevation) {
+	self.elevation = e
 }
 
 // Returns the child elements
@@ -163,42 +214,82 @@ func (self *CircularTrack) ZeroObjects() {
 }
 
 func (self *CircularTrack) RandomizeObjects() {
-	rand.Seed(42)
+	// laid out from the world's seed, the same layout for the same -seed
 
 	// create a new slice for child radians
 	rads := make([]float64, len(self.childs))
 	for idx, _ := range self.childs {
-		theta := rand.Float64() * 2 * math.Pi
+		theta := self.world.Rand.Float64() * 2 * math.Pi
 		rads[idx] = theta
-
-		fmt.Printf("RandomizeObjects: %d %.2f\n", theta)
 	}
 	self.rads = rads
 	self.ComputeNewCoords()
 }
 
+// Place child at theta radians round the track, once laid out
+func (self *CircularTrack) Place(child Object, theta float64) {
+	theta = math.Mod(theta, 2*math.Pi)
+	if theta < 0 {
+		theta += 2 * math.Pi
+	}
+	for i, c := range self.childs {
+		if c == child {
+			self.rads[i] = theta
+		}
+	}
+	self.ComputeNewCoords()
+}
+
 func (self *CircularTrack) Tick() {
+	start := time.Now()
+	self.world.Apply()
+	self.world.Clock.Tick()
+	self.Spawn()
 	for i := 0; i < len(self.childs); i++ {
 		self.childs[i].Tick()
 	}
 	self.ComputeNewPositions()
 	self.ComputeNewCoords()
 	self.ComputeHints()
+	self.ComputeCrews()
+	self.ComputeFleet()
+	self.world.Settle()
+	self.world.Metrics.Observe(self, self.world.Clock, time.Since(start))
+}
+
+// Spawn adds any vehicles due from the Spawner
+func (self *CircularTrack) Spawn() {
+	if self.spawn == nil {
+		return
+	}
+	count := 0
+	for _, val := range self.childs {
+		if v, ok := val.(*Vehicle); ok && v.Fleet == nil {
+			count++
+		}
+	}
+	for _, v := range self.spawn.Spawn(self.world, count) {
+		self.Add(v)
+		self.world.Event(Event{Kind: SpawnEvent, Vehicle: v.Name, Model: v.Model, Charge: v.Charge})
+	}
 }
 
 func (self *CircularTrack) ComputeNewPositions() {
-	// only the Vehicles
-	vi := make(map[int]*Vehicle, len(self.childs))
+	// only the Vehicles, and crews
+	vi := make(map[int]float64, len(self.childs))
 	for i := 0; i < len(self.childs); i++ {
 		switch v := self.childs[i].(type) {
 		case *Vehicle:
-			vi[i] = v
+			vi[i] = v.Velocity
+			break
+		case *Crew:
+			vi[i] = v.Velocity
 			break
 		}
 	}
 	for i := range vi {
 		t := 1.0 // time tick
-		v := vi[i].Velocity
+		v := vi[i]
 		p := self.rads[i]
 
 		w := v / self.radius
@@ -239,12 +330,10 @@ func (self *CircularTrack) Direction(theta, velocity float64) float64 {
 		if math.Signbit(velocity) {
 			// car is going clockwise
 			// keep going
-			fmt.Println("Keep going clockwise...")
 			return -1
 		} else {
 			// car is going anticlockwise
 			// gone too far, turn back
-			fmt.Println("Turning back clockwise...")
 			return -1
 		}
 	} else {
@@ -252,12 +341,10 @@ func (self *CircularTrack) Direction(theta, velocity float64) float64 {
 		if math.Signbit(velocity) {
 			// gone too far,
 			// turn back
-			fmt.Println("Turning back... anticlockwise")
 			return 1
 		} else {
 			// car is going anticlockwise,
 			// continue
-			fmt.Println("Keep going... anticlockwise")
 			return 1
 		}
 	}
@@ -279,72 +366,196 @@ func (self *CircularTrack) InRange(l, r float64) bool {
 func (self *CircularTrack) ComputeHints() {
 	// TODO look into composition instead of this.
 
-	// map of supported types
+	// map of supported types, destination chargers kept apart as they
+	// are no use to a vehicle looking to charge en route
 	vi := make(map[int]*Vehicle, len(self.childs))
 	ci := make(map[int]*Charger, len(self.childs))
+	ai := make(map[int]*Charger, len(self.childs))
 	for i := 0; i < len(self.childs); i++ {
 		switch v := self.childs[i].(type) {
 		case *Vehicle:
 			vi[i] = v
 			break
 		case *Charger:
-			ci[i] = v
+			if !v.Online() {
+				// faulted, no use to anyone
+				break
+			}
+			if v.AC {
+				ai[i] = v
+			} else {
+				ci[i] = v
+			}
 			break
 		}
 	}
 
 	// for all Vehicles, provide a Hints structure
 	// NOTE we can modify map inplace and effect self.childs by reference.
-	var vr, cr, theta float64
-	var v *Vehicle
 	for vdx := range vi {
-		v = vi[vdx]
-
-		// Find nearest Chargers
-		thetas := make(map[float64]*Charger, len(ci))
-		// prepare sorted list of theta
-		vr = self.rads[vdx]
-		for cdx := range ci {
-			cr = self.rads[cdx]
-
-			// directional, -ve indicating clockwise
-			theta = cr - vr
-			fmt.Printf("ch %.2f - vehicle %.2f = theta %.2f\n",
-				cr, vr, theta)
-
-			// correct for going beyond pi (180deg)
-			if math.Abs(theta) > math.Pi {
-				theta = (2*math.Pi - math.Abs(theta)) * -1
+		self.ComputeTerrain(vdx, vi[vdx])
+		vi[vdx].SetHints(self.hintsFor(vdx, vi[vdx], ci))
+		vi[vdx].SetDestinations(self.hintsFor(vdx, vi[vdx], ai))
+	}
+}
+
+// ComputeCrews sends idle crews to the nearest faulted charger nobody is
+// seeing to, and steers those travelling toward their charger.
+func (self *CircularTrack) ComputeCrews() {
+	ci := make(map[*Charger]int, len(self.childs))
+	for i := 0; i < len(self.childs); i++ {
+		if c, ok := self.childs[i].(*Charger); ok {
+			ci[c] = i
+		}
+	}
+	for i := 0; i < len(self.childs); i++ {
+		crew, ok := self.childs[i].(*Crew)
+		if !ok {
+			continue
+		}
+		if crew.Status == "idle" {
+			var nearest *Charger
+			for c, cdx := range ci {
+				if c.Fault == "" || c.crew != nil || c.Remote {
+					continue
+				}
+				if nearest == nil || math.Abs(self.theta(i, cdx)) < math.Abs(self.theta(i, ci[nearest])) {
+					nearest = c
+				}
+			}
+			if nearest == nil {
+				continue
 			}
-			// track map of radians and Charger
-			thetas[theta] = ci[cdx]
+			crew.Dispatch(nearest)
 		}
-		// sort smallest to largest by Abs value
-		thetaKeys := make([]float64, 0, len(ci))
-		for kt := range thetas {
-			thetaKeys = append(thetaKeys, kt)
+		if target := crew.Target(); target != nil {
+			theta := self.theta(i, ci[target])
+			crew.Head(self.Length(theta), theta)
 		}
-		sort.Slice(thetaKeys, func(i, j int) bool {
-			return math.Abs(thetaKeys[i]) < math.Abs(thetaKeys[j])
-		})
+	}
+}
+
+// ComputeFleet lets the fleet operator dispatch, once everything has moved
+func (self *CircularTrack) ComputeFleet() {
+	if self.world.Fleet != nil {
+		self.world.Fleet.Dispatch(self)
+	}
+}
 
-		// Ordered list of hints, per vehicle, sized by chargers
-		hints := make([]*Hint, 0, len(ci))
-		// prepare a list of structures
-		for kt := range thetaKeys {
-			hints = append(hints, &Hint{
-				TrackLength: self.Length(2 * math.Pi),
-				Dist:        self.Length(thetaKeys[kt]),
-				Theta:       thetaKeys[kt],
-				Vector:      self.Direction(thetaKeys[kt], v.Velocity),
-				Range:       v.CalcRange(),
-				InRange:     self.InRange(self.Length(math.Abs(thetaKeys[kt])), v.CalcRange()),
-				NextRange:   self.InRange(self.Length(2*math.Pi+math.Abs(thetaKeys[kt])), v.CalcRange()),
-				Charger:     thetas[thetaKeys[kt]],
-			})
+// Position of o round the track, in radians
+func (self *CircularTrack) Position(o Object) float64 {
+	for i, c := range self.childs {
+		if c == o {
+			return self.rads[i]
 		}
-		v.SetHints(hints)
 	}
+	return 0
+}
+
+// Distance along the track between positions, the shorter way round:
+// positive is anticlockwise.
+func (self *CircularTrack) Distance(from, to float64) float64 {
+	theta := math.Mod(to-from, 2*math.Pi)
+	if math.Abs(theta) > math.Pi {
+		theta -= math.Copysign(2*math.Pi, theta)
+	}
+	return theta * self.radius
+}
+
+// Climb in metres going between positions the shorter way round, and
+// descent
+func (self *CircularTrack) Climb(from, to float64) (up, down float64) {
+	return self.elevation.Climb(from, self.Distance(from, to)/self.radius)
+}
+
+// Somewhere on the track, at random
+func (self *CircularTrack) Somewhere() float64 {
+	return self.world.Rand.Float64() * 2 * math.Pi
+}
+
+// theta from the child at from to the child at to, the shorter way round,
+// -ve indicating clockwise
+func (self *CircularTrack) theta(from, to int) float64 {
+	theta := self.rads[to] - self.rads[from]
+	if math.Abs(theta) > math.Pi {
+		theta = (2*math.Pi - math.Abs(theta)) * -math.Copysign(1, theta)
+	}
+	return theta
+}
+
+// ComputeTerrain passes the vehicle at vdx the gradient under it, and the
+// climbing and descending across its range ahead.
+func (self *CircularTrack) ComputeTerrain(vdx int, v *Vehicle) {
+	dir := 1.0
+	if math.Signbit(v.Velocity) {
+		dir = -1.0
+	}
+	theta := self.rads[vdx]
+
+	dist := math.Max(math.Abs(v.Velocity), 0.5)
+	step := dist / self.radius * dir
+	grade := (self.elevation.Height(theta+step) - self.elevation.Height(theta)) / dist
+
+	// terrain is the same every lap, so no further than once around
+	ahead := math.Min(v.RangeOver(0, 0), self.Length(2*math.Pi))
+	up, down := self.elevation.Climb(theta, ahead/self.radius*dir)
+	v.SetTerrain(grade, up, down)
+}
+
+// Ordered list of hints from the vehicle at vdx to each of the chargers
+func (self *CircularTrack) hintsFor(vdx int, v *Vehicle, ci map[int]*Charger) []*Hint {
+	var vr, cr, theta float64
+
+	// Find nearest Chargers
+	thetas := make(map[float64]*Charger, len(ci))
+	// prepare sorted list of theta
+	vr = self.rads[vdx]
+	for cdx := range ci {
+		cr = self.rads[cdx]
+
+		// directional, -ve indicating clockwise
+		theta = cr - vr
+
+		// correct for going beyond pi (180deg)
+		if math.Abs(theta) > math.Pi {
+			theta = (2*math.Pi - math.Abs(theta)) * -1
+		}
+		// track map of radians and Charger
+		thetas[theta] = ci[cdx]
+	}
+	// sort smallest to largest by Abs value
+	thetaKeys := make([]float64, 0, len(ci))
+	for kt := range thetas {
+		thetaKeys = append(thetaKeys, kt)
+	}
+	sort.Slice(thetaKeys, func(i, j int) bool {
+		return math.Abs(thetaKeys[i]) < math.Abs(thetaKeys[j])
+	})
+
+	// Ordered list of hints, per vehicle, sized by chargers
+	hints := make([]*Hint, 0, len(ci))
+	// prepare a list of structures
+	for kt := range thetaKeys {
+		theta = thetaKeys[kt]
+		up, down := self.elevation.Climb(vr, theta)
+		// next time round, with a full lap of hills first
+		lap := math.Copysign(2*math.Pi, self.Direction(theta, v.Velocity))
+		nextUp, nextDown := self.elevation.Climb(vr, theta+lap)
+		hints = append(hints, &Hint{
+			TrackLength: self.Length(2 * math.Pi),
+			Dist:        self.Length(theta),
+			Climb:       up,
+			Descent:     down,
+			Theta:       theta,
+			Vector:      self.Direction(theta, v.Velocity),
+			Range:       v.CalcRange(),
+			InRange:     self.InRange(self.Length(math.Abs(theta)), v.RangeOver(up, down)),
+			NextRange:   self.InRange(self.Length(2*math.Pi+math.Abs(theta)), v.RangeOver(nextUp, nextDown)),
+			Charger:     thetas[theta],
+			Price:       thetas[theta].Price().PerKWh,
+		})
+	}
+	return hints
 }
 
 func (self *CircularTrack) coords(rad float64) (float64, float64) {
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// V2G is when the grid wants energy back from vehicles, and what drivers
+// are paid for it: during daily windows, or whenever the grid signals.
+type V2G struct {
+	Windows []PricePeriod // discharge windows, PerKWh paid to drivers
+	Signal  bool          // the grid asks for discharge now
+	Price   int64         // per kWh paid on a signal outside the windows
+
+	// dispatchable capacity offered this tick, and by hour
+	kW, kWh  float64
+	vehicles int
+	hours    map[string]*Dispatchable
+}
+
+// Dispatchable capacity plugged in over an hour, averaged over its ticks
+type Dispatchable struct {
+	Hour     string
+	Vehicles float64
+	KW       float64
+	KWh      float64
+	ticks    int
+}
+
+// NewV2G discharges over the evening peak
+func NewV2G(price int64) *V2G {
+	return &V2G{
+		Windows: []PricePeriod{{From: 17, To: 21, PerKWh: price}},
+		Price:   price,
+		hours:   make(map[string]*Dispatchable),
+	}
+}
+
+// Paying for discharge now, and at what price per kWh
+func (g *V2G) Paying(now time.Time) (int64, bool) {
+	price, ok := int64(0), false
+	for _, w := range g.Windows {
+		if w.Covers(now.Hour()) {
+			price, ok = w.PerKWh, true
+		}
+	}
+	if g.Signal && !ok {
+		price, ok = g.Price, true
+	}
+	return price, ok
+}
+
+// Offer a vehicle's capacity to discharge for this tick
+func (g *V2G) Offer(kW, kWh float64) {
+	g.vehicles++
+	g.kW += kW
+	g.kWh += kWh
+}
+
+// Sample the capacity offered this tick into its hour
+func (g *V2G) Sample(now time.Time) {
+	hour := now.Format("2006-01-02 15:00")
+	d, ok := g.hours[hour]
+	if !ok {
+		d = &Dispatchable{Hour: hour}
+		g.hours[hour] = d
+	}
+	d.ticks++
+	n := float64(d.ticks)
+	d.Vehicles += (float64(g.vehicles) - d.Vehicles) / n
+	d.KW += (g.kW - d.KW) / n
+	d.KWh += (g.kWh - d.KWh) / n
+	g.vehicles, g.kW, g.kWh = 0, 0, 0
+}
+
+// Dispatchable capacity by hour, in order
+func (g *V2G) Dispatchable() []*Dispatchable {
+	rows := make([]*Dispatchable, 0, len(g.hours))
+	for _, d := range g.hours {
+		rows = append(rows, d)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Hour < rows[j].Hour
+	})
+	return rows
+}
+
+// Report writes the dispatchable capacity by hour as CSV
+func (g *V2G) Report(w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"hour", "vehicles", "kw", "kwh"})
+	for _, d := range g.Dispatchable() {
+		out.Write([]string{
+			d.Hour,
+			fmt.Sprintf("%.2f", d.Vehicles),
+			fmt.Sprintf("%.2f", d.KW),
+			fmt.Sprintf("%.2f", d.KWh),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// discharge limits of v plugged in here: kW, and kWh above its minimum
+// state of charge. Zero when either can't discharge.
+func (c *Charger) discharge(v *Vehicle) (float64, float64) {
+	spec := v.Spec()
+	if c.world.V2G == nil || c.V2GKW == 0 || spec.V2GKW == 0 || v.Charge <= v.MinSoC {
+		return 0, 0
+	}
+	return math.Min(c.V2GKW, spec.V2GKW), (v.Charge - v.MinSoC) / 100 * v.Capacity()
+}
+
+// Offer v's capacity to the grid, when it could discharge
+func (c *Charger) Offer(v *Vehicle) {
+	if kW, kWh := c.discharge(v); kW > 0 {
+		c.world.V2G.Offer(kW, kWh)
+	}
+}
+
+// Dispatching v to the grid now, at what price per kWh. Vehicles sit
+// idle once down to their minimum.
+func (c *Charger) Dispatching(v *Vehicle) (int64, bool) {
+	if c.world.V2G == nil || c.V2GKW == 0 || v.Spec().V2GKW == 0 {
+		return 0, false
+	}
+	return c.world.V2G.Paying(c.world.Clock.Now())
+}
+
+// Discharge v to the grid for a tick. Returns the kWh exported.
+func (c *Charger) Discharge(v *Vehicle) float64 {
+	kW, room := c.discharge(v)
+	kWh := math.Min(kW*c.world.Clock.Hours(), room)
+	if kWh <= 0 {
+		return 0
+	}
+	v.Charge -= kWh / v.Capacity() * 100
+	v.Wear(kWh, false)
+	c.world.Site(c.Site).Export(kWh)
+	return kWh
+}
+
+// export records a tick of v's session discharging kWh, paid at price
+func (c *Charger) export(v *Vehicle, kWh float64, price int64) {
+	s, ok := c.sessions[v]
+	if !ok {
+		return
+	}
+	s.Exported += kWh
+	s.Credit += kWh * float64(price)
+}
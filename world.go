@@ -0,0 +1,145 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/rooprob/chargesim/message"
+)
+
+// World is the shared simulation state a Track hands to its Objects.
+type World struct {
+	Clock          *Clock
+	Demand         *Demand
+	Environment    Environment
+	Degradation    *Degradation // nil for batteries that never wear
+	Regen          float64      // share of descent energy recovered
+	Faults         bool         // chargers fail at random, see Reliability
+	V2G            *V2G         // nil when vehicles never discharge to the grid
+	DemandResponse *DemandResponse
+	Fleet          *FleetOperator // nil without a ride-hail fleet
+	Metrics        *Metrics
+	Events         *EventLog // nil writes no events
+	Billing        *Billing
+	Ledger         *Ledger
+	Rand           *rand.Rand
+
+	// defaults for sites as chargers first draw from them
+	Currency     string
+	Energy       EnergyPrice
+	DemandCharge int64
+	GridKW       float64
+	Solar        Solar
+	BatteryKWh   float64 // a battery of this size at each site, if any
+	BatteryKW    float64
+	Dispatch     DispatchPolicy
+	sites        map[string]*Site
+	crews        []*Crew
+
+	requests chan func()
+}
+
+func NewWorld(clock *Clock, seed int64) *World {
+	ledger := NewLedger()
+	w := &World{
+		Clock:          clock,
+		Demand:         NewDemand(),
+		Environment:    FixedTemperature(20.0),
+		Regen:          0.6,
+		Billing:        NewBilling(ledger),
+		DemandResponse: NewDemandResponse(),
+		Metrics:        NewMetrics(),
+		Ledger:         ledger,
+		Rand:           rand.New(rand.NewSource(seed)),
+
+		Currency:     "USD",
+		Energy:       FlatEnergy(15.0),
+		DemandCharge: 0,
+		Dispatch:     SolarFirst{},
+		sites:        make(map[string]*Site),
+
+		requests: make(chan func(), 64),
+	}
+	w.DemandResponse.OnEvent(func(e *message.DemandResponse) {
+		w.Event(Event{Kind: ResponseEvent, Id: e.Id, Site: e.Site, KW: e.ReduceKW, To: e.Status})
+	})
+	return w
+}
+
+// Do runs f between ticks, for changes arriving from outside the
+// simulation loop.
+func (w *World) Do(f func()) {
+	w.requests <- f
+}
+
+// Apply runs the changes requested since the last tick
+func (w *World) Apply() {
+	for {
+		select {
+		case f := <-w.requests:
+			f()
+		default:
+			return
+		}
+	}
+}
+
+// Site by name, opened with the World's defaults if new
+func (w *World) Site(name string) *Site {
+	s, ok := w.sites[name]
+	if !ok {
+		s = NewSite(name, w.Currency, w.Energy, w.DemandCharge)
+		s.GridKW = w.GridKW
+		s.Solar = w.Solar
+		s.Dispatch = w.Dispatch
+		if w.BatteryKWh > 0 {
+			s.Battery = NewBattery(w.BatteryKWh, w.BatteryKW)
+		}
+		w.sites[name] = s
+	}
+	return s
+}
+
+func (w *World) Sites() []*Site {
+	sites := make([]*Site, 0, len(w.sites))
+	for _, s := range w.sites {
+		sites = append(sites, s)
+	}
+	return sites
+}
+
+// Settle accounts for the tick just run
+func (w *World) Settle() {
+	for _, s := range w.sites {
+		s.Settle(w.Clock, w.Ledger)
+	}
+	if w.V2G != nil {
+		w.V2G.Sample(w.Clock.Now())
+	}
+	w.DemandResponse.Update(w.Clock.Now(), w.Sites())
+}
+
+// Close the accounts at the end of a run, paying for the open billing
+// periods.
+func (w *World) Close() {
+	for _, s := range w.sites {
+		s.CloseBillingPeriod(w.Clock.Now(), w.Ledger)
+	}
+}
+
+// Temperature is the ambient temperature now
+func (w *World) Temperature() float64 {
+	return w.Environment.Temperature(w.Clock.Now())
+}
+
+// Chance rolls for an event happening at perHour over the current tick.
+func (w *World) Chance(perHour float64) bool {
+	p := math.Min(1.0, perHour*w.Clock.Hours())
+	return w.Rand.Float64() < p
+}
+
+// Dwell draws a stay around mean, mostly short with the occasional long one.
+func (w *World) Dwell(mean time.Duration) time.Duration {
+	return time.Duration(w.Rand.ExpFloat64() * float64(mean))
+}